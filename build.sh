@@ -18,20 +18,22 @@ if [ "$CURRENT_ARCH" = "x86_64" ]; then
 fi
 
 if [ "$CURRENT_OS" = "darwin" ]; then
-    go build -ldflags "$LDFLAGS" -o dist/chat-app-macos-$CURRENT_ARCH
+    go build -tags sqlite_fts5 -ldflags "$LDFLAGS" -o dist/chat-app-macos-$CURRENT_ARCH
     mkdir -p dist/chat-app-macos-$CURRENT_ARCH-package
     cp dist/chat-app-macos-$CURRENT_ARCH dist/chat-app-macos-$CURRENT_ARCH-package/
     cp -r static dist/chat-app-macos-$CURRENT_ARCH-package/
     cp -r templates dist/chat-app-macos-$CURRENT_ARCH-package/
+    cp config.example.yaml dist/chat-app-macos-$CURRENT_ARCH-package/
     cd dist && tar -czf chat-app-macos-$CURRENT_ARCH-with-sqlite.tar.gz chat-app-macos-$CURRENT_ARCH-package && cd ..
     rm -rf dist/chat-app-macos-$CURRENT_ARCH-package
     echo "已创建本地MacOS版本（支持SQLite）: dist/chat-app-macos-$CURRENT_ARCH-with-sqlite.tar.gz"
 elif [ "$CURRENT_OS" = "linux" ]; then
-    go build -ldflags "$LDFLAGS" -o dist/chat-app-linux-$CURRENT_ARCH
+    go build -tags sqlite_fts5 -ldflags "$LDFLAGS" -o dist/chat-app-linux-$CURRENT_ARCH
     mkdir -p dist/chat-app-linux-$CURRENT_ARCH-package
     cp dist/chat-app-linux-$CURRENT_ARCH dist/chat-app-linux-$CURRENT_ARCH-package/
     cp -r static dist/chat-app-linux-$CURRENT_ARCH-package/
     cp -r templates dist/chat-app-linux-$CURRENT_ARCH-package/
+    cp config.example.yaml dist/chat-app-linux-$CURRENT_ARCH-package/
     cd dist && tar -czf chat-app-linux-$CURRENT_ARCH-with-sqlite.tar.gz chat-app-linux-$CURRENT_ARCH-package && cd ..
     rm -rf dist/chat-app-linux-$CURRENT_ARCH-package
     echo "已创建本地Linux版本（支持SQLite）: dist/chat-app-linux-$CURRENT_ARCH-with-sqlite.tar.gz"
@@ -44,33 +46,36 @@ export CGO_ENABLED=0
 echo "打包Linux x64版本..."
 export GOOS=linux
 export GOARCH=amd64
-go build -ldflags "$LDFLAGS" -o dist/chat-app-linux-amd64
+go build -tags sqlite_fts5 -ldflags "$LDFLAGS" -o dist/chat-app-linux-amd64
 mkdir -p dist/chat-app-linux-amd64-package
 cp dist/chat-app-linux-amd64 dist/chat-app-linux-amd64-package/
 cp -r static dist/chat-app-linux-amd64-package/
 cp -r templates dist/chat-app-linux-amd64-package/
+cp config.example.yaml dist/chat-app-linux-amd64-package/
 cd dist && tar -czf chat-app-linux-amd64.tar.gz chat-app-linux-amd64-package && cd ..
 rm -rf dist/chat-app-linux-amd64-package
 
 echo "打包MacOS Intel版本..."
 export GOOS=darwin
 export GOARCH=amd64
-go build -ldflags "$LDFLAGS" -o dist/chat-app-macos-amd64
+go build -tags sqlite_fts5 -ldflags "$LDFLAGS" -o dist/chat-app-macos-amd64
 mkdir -p dist/chat-app-macos-amd64-package
 cp dist/chat-app-macos-amd64 dist/chat-app-macos-amd64-package/
 cp -r static dist/chat-app-macos-amd64-package/
 cp -r templates dist/chat-app-macos-amd64-package/
+cp config.example.yaml dist/chat-app-macos-amd64-package/
 cd dist && tar -czf chat-app-macos-amd64.tar.gz chat-app-macos-amd64-package && cd ..
 rm -rf dist/chat-app-macos-amd64-package
 
 echo "打包Windows x64版本..."
 export GOOS=windows
 export GOARCH=amd64
-go build -ldflags "$LDFLAGS" -o dist/chat-app-windows-amd64.exe
+go build -tags sqlite_fts5 -ldflags "$LDFLAGS" -o dist/chat-app-windows-amd64.exe
 mkdir -p dist/chat-app-windows-amd64-package
 cp dist/chat-app-windows-amd64.exe dist/chat-app-windows-amd64-package/
 cp -r static dist/chat-app-windows-amd64-package/
 cp -r templates dist/chat-app-windows-amd64-package/
+cp config.example.yaml dist/chat-app-windows-amd64-package/
 cd dist && zip -r chat-app-windows-amd64.zip chat-app-windows-amd64-package && cd ..
 rm -rf dist/chat-app-windows-amd64-package
 
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 未指定配置文件时尝试加载的默认文件
+const DefaultFile = "config.yaml"
+
+// 数据存储类型
+const (
+	StorageAuto   = "auto"   // 优先使用SQLite，不可用时使用内存
+	StorageSQLite = "sqlite" // SQLite文件数据库
+	StorageMemory = "memory" // 内存存储，重启后数据丢失
+)
+
+// 环境变量前缀，例如 -recall-window 对应 CHAT_RECALL_WINDOW
+const envPrefix = "CHAT_"
+
+// Config 是服务端配置
+//
+// 优先级从低到高为：默认值、配置文件、环境变量、命令行参数
+type Config struct {
+	Addr            string        `yaml:"addr"`
+	Storage         string        `yaml:"storage"`
+	DBFile          string        `yaml:"db_file"`
+	RecallWindow    time.Duration `yaml:"recall_window"`
+	EditWindow      time.Duration `yaml:"edit_window"`
+	OnlineWindow    time.Duration `yaml:"online_window"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+	ChatTitle       string        `yaml:"chat_title"`
+	StaticDir       string        `yaml:"static_dir"`
+	TemplateDir     string        `yaml:"template_dir"`
+	UploadDir       string        `yaml:"upload_dir"`
+	Admins          []string      `yaml:"admins"`          // 管理员的登录名
+	TrustedProxies  []string      `yaml:"trusted_proxies"` // 可信反向代理的IP或CIDR，只采信它们转发的X-Forwarded-For
+	AllowedOrigins  []string      `yaml:"allowed_origins"` // 除同源页面外允许建立WebSocket连接的来源，如 https://chat.example.com
+	MaxFrameSize    int64         `yaml:"max_frame_size"`  // WebSocket单个消息的最大字节数
+	MaxTextLength   int           `yaml:"max_text_length"` // 文本消息的最大字符数
+	ReplayLimit     int           `yaml:"replay_limit"`    // 断线重连时最多补发的消息数
+
+	// WebSocket心跳：每隔PingInterval发送ping，PongTimeout内没有收到pong或其他消息时断开连接；
+	// 每次写入最多等待WriteTimeout
+	PingInterval time.Duration `yaml:"ping_interval"`
+	PongTimeout  time.Duration `yaml:"pong_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// 超过AwayAfter没有操作的在线用户自动显示为离开，为0时不自动显示
+	AwayAfter time.Duration `yaml:"away_after"`
+
+	// 每个连接的令牌桶限流，每隔interval补充一个令牌，最多积累burst个
+	MessageInterval time.Duration `yaml:"message_interval"`
+	MessageBurst    int           `yaml:"message_burst"`
+	UploadInterval  time.Duration `yaml:"upload_interval"`
+	UploadBurst     int           `yaml:"upload_burst"`
+	RenameInterval  time.Duration `yaml:"rename_interval"`
+	RenameBurst     int           `yaml:"rename_burst"`
+	ReadInterval    time.Duration `yaml:"read_interval"`
+	ReadBurst       int           `yaml:"read_burst"`
+
+	// 在FloodWindow内超出限制FloodViolations次后自动禁言FloodMute，FloodViolations为0时不自动禁言
+	FloodViolations int           `yaml:"flood_violations"`
+	FloodWindow     time.Duration `yaml:"flood_window"`
+	FloodMute       time.Duration `yaml:"flood_mute"`
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Addr:            ":8081",
+		Storage:         StorageAuto,
+		DBFile:          "chat.db",
+		RecallWindow:    8 * time.Hour,
+		EditWindow:      8 * time.Hour,
+		OnlineWindow:    30 * time.Second,
+		CleanupInterval: 5 * time.Minute,
+		ChatTitle:       "局域网聊天室",
+		StaticDir:       "static",
+		TemplateDir:     "templates",
+		UploadDir:       filepath.Join("data", "files"),
+		MaxFrameSize:    64 << 10,
+		MaxTextLength:   4000,
+		ReplayLimit:     200,
+		PingInterval:    20 * time.Second,
+		PongTimeout:     45 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		AwayAfter:       5 * time.Minute,
+		MessageInterval: 500 * time.Millisecond,
+		MessageBurst:    10,
+		UploadInterval:  10 * time.Second,
+		UploadBurst:     5,
+		RenameInterval:  time.Minute,
+		RenameBurst:     3,
+		ReadInterval:    200 * time.Millisecond,
+		ReadBurst:       20,
+		FloodViolations: 5,
+		FloodWindow:     time.Minute,
+		FloodMute:       5 * time.Minute,
+	}
+}
+
+// bind 把各项配置注册为命令行参数，参数名同时用于推导环境变量名
+func bind(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "监听地址")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "数据存储：auto、sqlite或memory")
+	fs.StringVar(&c.DBFile, "db", c.DBFile, "SQLite数据库文件")
+	fs.DurationVar(&c.RecallWindow, "recall-window", c.RecallWindow, "消息可撤回的时间")
+	fs.DurationVar(&c.EditWindow, "edit-window", c.EditWindow, "消息可编辑的时间")
+	fs.DurationVar(&c.OnlineWindow, "online-window", c.OnlineWindow, "最近活跃多久内视为在线")
+	fs.DurationVar(&c.CleanupInterval, "cleanup-interval", c.CleanupInterval, "清理不活跃用户的间隔")
+	fs.StringVar(&c.ChatTitle, "title", c.ChatTitle, "聊天室名称")
+	fs.StringVar(&c.StaticDir, "static-dir", c.StaticDir, "静态文件目录")
+	fs.StringVar(&c.TemplateDir, "template-dir", c.TemplateDir, "HTML模板目录")
+	fs.StringVar(&c.UploadDir, "upload-dir", c.UploadDir, "上传文件存储目录")
+	fs.Var((*stringList)(&c.Admins), "admins", "管理员的登录名，多个用逗号分隔")
+	fs.Var((*stringList)(&c.AllowedOrigins), "allowed-origins", "除同源页面外允许建立WebSocket连接的来源，多个用逗号分隔")
+	fs.Var((*stringList)(&c.TrustedProxies), "trusted-proxies", "可信反向代理的IP或CIDR，多个用逗号分隔，为空时使用连接的来源IP")
+	fs.Int64Var(&c.MaxFrameSize, "max-frame-size", c.MaxFrameSize, "WebSocket单个消息的最大字节数，超出时断开连接")
+	fs.IntVar(&c.MaxTextLength, "max-text-length", c.MaxTextLength, "文本消息的最大字符数")
+	fs.DurationVar(&c.PingInterval, "ping-interval", c.PingInterval, "WebSocket心跳ping的发送间隔")
+	fs.DurationVar(&c.PongTimeout, "pong-timeout", c.PongTimeout, "多久没有收到pong或消息时断开连接，必须大于ping-interval")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "WebSocket每次写入的超时时间")
+	fs.DurationVar(&c.AwayAfter, "away-after", c.AwayAfter, "多久没有操作后自动显示为离开，为0时不自动显示")
+	fs.IntVar(&c.ReplayLimit, "replay-limit", c.ReplayLimit, "断线重连时最多补发的消息数，超出时通知客户端重新加载")
+	fs.DurationVar(&c.MessageInterval, "message-interval", c.MessageInterval, "每个连接发送消息的令牌补充间隔")
+	fs.IntVar(&c.MessageBurst, "message-burst", c.MessageBurst, "每个连接最多连续发送的消息数")
+	fs.DurationVar(&c.UploadInterval, "upload-interval", c.UploadInterval, "每个用户上传文件的令牌补充间隔")
+	fs.IntVar(&c.UploadBurst, "upload-burst", c.UploadBurst, "每个用户最多连续上传的文件数")
+	fs.DurationVar(&c.RenameInterval, "rename-interval", c.RenameInterval, "每个连接修改昵称的令牌补充间隔")
+	fs.IntVar(&c.RenameBurst, "rename-burst", c.RenameBurst, "每个连接最多连续修改昵称的次数")
+	fs.DurationVar(&c.ReadInterval, "read-interval", c.ReadInterval, "每个连接发送已读回执的令牌补充间隔")
+	fs.IntVar(&c.ReadBurst, "read-burst", c.ReadBurst, "每个连接最多连续发送的已读回执数，超出的回执直接丢弃")
+	fs.IntVar(&c.FloodViolations, "flood-violations", c.FloodViolations, "超出限制多少次后自动禁言，为0时不自动禁言")
+	fs.DurationVar(&c.FloodWindow, "flood-window", c.FloodWindow, "统计超出限制次数的时间范围")
+	fs.DurationVar(&c.FloodMute, "flood-mute", c.FloodMute, "自动禁言的时长")
+}
+
+// stringList 是用逗号分隔的字符串列表参数，设置时替换原有的值
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*l = items
+	return nil
+}
+
+// 命令行参数名对应的环境变量名
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load 依次读取配置文件、环境变量和命令行参数，并校验最终配置
+func Load(args []string) (*Config, error) {
+	return load(args, (*Config).Validate)
+}
+
+// LoadDatabase 与Load相同，但只校验数据库相关的配置，供不启动服务器的migrate子命令使用
+func LoadDatabase(args []string) (*Config, error) {
+	return load(args, (*Config).ValidateDatabase)
+}
+
+// 读取配置并用validate校验
+func load(args []string, validate func(c *Config) error) (*Config, error) {
+	fs := flag.NewFlagSet("chat-app", flag.ContinueOnError)
+	configFile := fs.String("config", "", "配置文件路径（YAML），也可通过 "+envPrefix+"CONFIG 指定")
+	bind(fs, Default())
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s [参数]\n\n每个参数也可以通过环境变量设置，例如 -recall-window 对应 %s\n\n", fs.Name(), envName("recall-window"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	// 配置文件
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path == "" {
+		path, required = DefaultFile, false
+	}
+	if err := cfg.loadFile(path, required); err != nil {
+		return nil, err
+	}
+
+	// 环境变量和命令行参数复用同一套参数解析
+	apply := flag.NewFlagSet("apply", flag.ContinueOnError)
+	bind(apply, cfg)
+	var err error
+	apply.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok && err == nil {
+			if setErr := apply.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("环境变量 %s 无效: %v", envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			apply.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 从YAML文件加载配置，文件中未出现的项保持原值
+func (c *Config) loadFile(path string, required bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("打开配置文件失败: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+// ValidateDatabase 校验数据存储相关的配置
+func (c *Config) ValidateDatabase() error {
+	switch c.Storage {
+	case StorageAuto, StorageSQLite, StorageMemory:
+	default:
+		return fmt.Errorf("数据存储 %q 无效，可选值为 auto、sqlite、memory", c.Storage)
+	}
+	if c.DBFile == "" {
+		return errors.New("数据库文件不能为空")
+	}
+	return nil
+}
+
+// Validate 校验配置是否可用
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("监听地址 %q 无效: %v", c.Addr, err)
+	}
+	if err := c.ValidateDatabase(); err != nil {
+		return err
+	}
+	if c.RecallWindow <= 0 {
+		return errors.New("撤回时间必须大于0")
+	}
+	if c.EditWindow <= 0 {
+		return errors.New("编辑时间必须大于0")
+	}
+	if c.OnlineWindow <= 0 {
+		return errors.New("在线判定时间必须大于0")
+	}
+	if c.CleanupInterval <= 0 {
+		return errors.New("清理间隔必须大于0")
+	}
+	if strings.TrimSpace(c.ChatTitle) == "" {
+		return errors.New("聊天室名称不能为空")
+	}
+	if c.UploadDir == "" {
+		return errors.New("上传文件目录不能为空")
+	}
+	if c.MaxTextLength <= 0 {
+		return errors.New("文本消息的最大字符数必须大于0")
+	}
+	if c.PingInterval <= 0 || c.WriteTimeout <= 0 {
+		return errors.New("心跳间隔和写入超时必须大于0")
+	}
+	if c.PongTimeout <= c.PingInterval {
+		return errors.New("心跳超时必须大于心跳间隔")
+	}
+	// 在线时间由心跳刷新，在线判定时间不大于心跳间隔时仍连接着的用户会被判定为离线
+	if c.OnlineWindow <= c.PingInterval {
+		return errors.New("在线判定时间必须大于心跳间隔")
+	}
+	if c.AwayAfter < 0 {
+		return errors.New("自动离开时间不能为负数")
+	}
+	if c.ReplayLimit <= 0 {
+		return errors.New("补发消息数必须大于0")
+	}
+	if c.MaxFrameSize < 1024 {
+		return errors.New("WebSocket消息的最大字节数不能小于1024")
+	}
+	if c.MessageInterval <= 0 || c.UploadInterval <= 0 || c.RenameInterval <= 0 || c.ReadInterval <= 0 {
+		return errors.New("限流的令牌补充间隔必须大于0")
+	}
+	if c.MessageBurst <= 0 || c.UploadBurst <= 0 || c.RenameBurst <= 0 || c.ReadBurst <= 0 {
+		return errors.New("限流的令牌数量必须大于0")
+	}
+	if c.FloodViolations < 0 {
+		return errors.New("自动禁言的超限次数不能为负数")
+	}
+	if c.FloodViolations > 0 && (c.FloodWindow <= 0 || c.FloodMute <= 0) {
+		return errors.New("自动禁言的统计时间和禁言时长必须大于0")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("可信代理 %q 不是有效的IP或CIDR", proxy)
+		}
+	}
+	for _, origin := range c.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("来源 %q 无效，格式应为 scheme://host[:port]", origin)
+		}
+	}
+	for _, dir := range []string{c.StaticDir, c.TemplateDir} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("目录 %q 不可用: %v", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%q 不是目录", dir)
+		}
+	}
+	return nil
+}
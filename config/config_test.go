@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 切换到包含static和templates目录的临时目录，避免读取到当前目录的config.yaml
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"static", "templates"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+// 在目录中写入配置文件，返回文件路径
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string            // 默认配置文件config.yaml的内容，为空时不创建
+		env    map[string]string // 环境变量
+		args   []string
+		recall time.Duration
+		edit   time.Duration
+		admins []string
+	}{
+		{
+			name:   "默认值",
+			recall: 8 * time.Hour,
+			edit:   8 * time.Hour,
+		},
+		{
+			name:   "配置文件覆盖默认值",
+			file:   "recall_window: 2h\nadmins: [alice, bob]\n",
+			recall: 2 * time.Hour,
+			edit:   8 * time.Hour,
+			admins: []string{"alice", "bob"},
+		},
+		{
+			name:   "环境变量覆盖配置文件",
+			file:   "recall_window: 2h\nedit_window: 2h\nadmins: [alice]\n",
+			env:    map[string]string{"CHAT_RECALL_WINDOW": "3h", "CHAT_ADMINS": " carol, ,dave "},
+			recall: 3 * time.Hour,
+			edit:   2 * time.Hour,
+			admins: []string{"carol", "dave"},
+		},
+		{
+			name:   "命令行参数覆盖环境变量",
+			file:   "recall_window: 2h\n",
+			env:    map[string]string{"CHAT_RECALL_WINDOW": "3h", "CHAT_EDIT_WINDOW": "3h"},
+			args:   []string{"-recall-window", "4h", "-admins", "erin"},
+			recall: 4 * time.Hour,
+			edit:   3 * time.Hour,
+			admins: []string{"erin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := chdirTemp(t)
+			if tt.file != "" {
+				writeConfig(t, dir, DefaultFile, tt.file)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("加载配置失败: %v", err)
+			}
+			if cfg.RecallWindow != tt.recall || cfg.EditWindow != tt.edit {
+				t.Errorf("撤回时间 = %s，编辑时间 = %s，应为 %s、%s", cfg.RecallWindow, cfg.EditWindow, tt.recall, tt.edit)
+			}
+			if len(cfg.Admins) != len(tt.admins) || (len(tt.admins) > 0 && !reflect.DeepEqual(cfg.Admins, tt.admins)) {
+				t.Errorf("管理员 = %q，应为 %q", cfg.Admins, tt.admins)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := chdirTemp(t)
+	writeConfig(t, dir, DefaultFile, "recall_window: 1h\n")
+	fromEnv := writeConfig(t, dir, "env.yaml", "recall_window: 2h\n")
+	fromFlag := writeConfig(t, dir, "flag.yaml", "recall_window: 3h\n")
+
+	// CHAT_CONFIG替代默认文件，-config参数优先于CHAT_CONFIG
+	t.Setenv("CHAT_CONFIG", fromEnv)
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.RecallWindow != 2*time.Hour {
+		t.Errorf("使用CHAT_CONFIG时撤回时间 = %s，应为 2h", cfg.RecallWindow)
+	}
+	cfg, err = Load([]string{"-config", fromFlag})
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.RecallWindow != 3*time.Hour {
+		t.Errorf("使用-config时撤回时间 = %s，应为 3h", cfg.RecallWindow)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+	}{
+		{name: "指定的配置文件不存在", args: []string{"-config", "missing.yaml"}},
+		{name: "配置文件中有未知的项", file: "recall_windows: 1h\n"},
+		{name: "配置文件格式错误", file: "recall_window: [\n"},
+		{name: "环境变量无效", env: map[string]string{"CHAT_RECALL_WINDOW": "abc"}},
+		{name: "命令行参数无效", args: []string{"-recall-window", "abc"}},
+		{name: "校验失败", env: map[string]string{"CHAT_PING_INTERVAL": "40s"}},
+		{name: "可信代理无效", args: []string{"-trusted-proxies", "bogus"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := chdirTemp(t)
+			if tt.file != "" {
+				writeConfig(t, dir, DefaultFile, tt.file)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if _, err := Load(tt.args); err == nil {
+				t.Error("加载配置应失败")
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 管理员用户在请求上下文中的键
+const adminContextKey = "admin"
+
+// RequireAdmin 只允许管理员访问后续的处理函数
+func RequireAdmin(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	if !user.IsAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+		return
+	}
+
+	c.Set(adminContextKey, user)
+	c.Next()
+}
+
+// 获取RequireAdmin校验过的管理员
+func currentAdmin(c *gin.Context) *models.User {
+	return c.MustGet(adminContextKey).(*models.User)
+}
+
+// 广播管理操作的系统消息，并保存到大厅
+func announceModeration(admin *models.User, content string) {
+	broadcastSystemMessage(admin.ID, content)
+}
+
+// 把管理操作的错误转换为响应
+func respondModerationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+	case errors.Is(err, models.ErrMessageNotFound), errors.Is(err, models.ErrBanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrModerateSelf), errors.Is(err, models.ErrModerateAdmin),
+		errors.Is(err, models.ErrInvalidMute), errors.Is(err, models.ErrInvalidBanTime),
+		errors.Is(err, models.ErrInvalidBanIP):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("管理操作失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+	}
+}
+
+// 解析路径中的ID参数
+func parseIDParam(c *gin.Context, name, invalid string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid})
+		return 0, false
+	}
+	return id, true
+}
+
+// 断开用户的所有连接，断开前通知用户原因
+func disconnectUser(userID int64, reason string) {
+	Hub.Disconnect(func(client *utils.Client) bool {
+		return client.ID == userID
+	}, &utils.Message{
+		Type:    utils.MessageTypeSystem,
+		Content: reason,
+	})
+}
+
+// DeleteMessage 管理员删除任意消息
+func DeleteMessage(c *gin.Context) {
+	messageID, ok := parseIDParam(c, "id", "消息ID无效")
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	msg, err := models.DeleteMessage(admin.ID, messageID)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+
+	// 客户端按撤回处理被删除的消息
+	notice := &utils.Message{
+		Type:      utils.MessageTypeRecall,
+		MessageID: msg.ID,
+		UserID:    msg.UserID,
+		RoomID:    msg.RoomID,
+		Data: map[string]interface{}{
+			"deleted_by_admin": true,
+		},
+	}
+	if msg.RecipientID != 0 {
+		notice.RoomID = 0
+		notice.TargetID = msg.RecipientID
+		Hub.SendToUsers([]int64{msg.UserID, msg.RecipientID}, notice)
+	} else {
+		Hub.BroadcastMessage(notice)
+
+		systemMsg := &utils.Message{
+			Type:    utils.MessageTypeSystem,
+			Content: fmt.Sprintf("管理员 %s 删除了一条消息", userDisplayName(admin)),
+			RoomID:  msg.RoomID,
+		}
+		if saved, err := models.CreateMessage(admin.ID, msg.RoomID, systemMsg.Content, models.MessageTypeSystem); err != nil {
+			log.Printf("保存系统消息失败: %v", err)
+		} else {
+			systemMsg.MessageID = saved.ID
+		}
+		Hub.BroadcastMessage(systemMsg)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// KickUser 把用户移出聊天室，断开其所有连接，用户可以重新连接
+func KickUser(c *gin.Context) {
+	userID, ok := parseIDParam(c, "id", "用户ID无效")
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	target, err := models.KickUser(admin.ID, userID)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+
+	disconnectUser(target.ID, "你已被管理员移出聊天室")
+	announceModeration(admin, fmt.Sprintf("%s 被管理员 %s 移出了聊天室", userDisplayName(target), userDisplayName(admin)))
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// 封禁和禁言请求，duration使用Go时长格式，例如30m、2h
+type moderationRequest struct {
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// 解析请求中的时长，为空时返回0
+func parseModerationRequest(c *gin.Context) (*moderationRequest, time.Duration, bool) {
+	var req moderationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式无效"})
+			return nil, 0, false
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	duration, ok := parseDuration(c, req.Duration)
+	if !ok {
+		return nil, 0, false
+	}
+	return &req, duration, true
+}
+
+// 解析时长，为空时返回0
+func parseDuration(c *gin.Context, text string) (time.Duration, bool) {
+	if text == "" {
+		return 0, true
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时长格式无效，例如30m、2h"})
+		return 0, false
+	}
+	return duration, true
+}
+
+// 封禁用户的系统消息内容
+func banAnnouncement(admin, target *models.User, duration time.Duration, reason string) string {
+	content := fmt.Sprintf("%s 被管理员 %s 封禁", userDisplayName(target), userDisplayName(admin))
+	if duration > 0 {
+		content += models.FormatDuration(duration)
+	}
+	if reason != "" {
+		content += "，原因：" + reason
+	}
+	return content
+}
+
+// BanUser 封禁用户并断开其所有连接，duration为空时永久封禁
+func BanUser(c *gin.Context) {
+	userID, ok := parseIDParam(c, "id", "用户ID无效")
+	if !ok {
+		return
+	}
+	req, duration, ok := parseModerationRequest(c)
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	target, ban, err := models.BanUser(admin.ID, userID, duration, req.Reason)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+
+	disconnectBanned(ban)
+	announceModeration(admin, banAnnouncement(admin, target, duration, req.Reason))
+
+	c.JSON(http.StatusOK, ban)
+}
+
+// MuteUser 禁言用户，禁言期间不能发送和编辑消息
+func MuteUser(c *gin.Context) {
+	userID, ok := parseIDParam(c, "id", "用户ID无效")
+	if !ok {
+		return
+	}
+	_, duration, ok := parseModerationRequest(c)
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	target, err := models.MuteUser(admin.ID, userID, duration)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+
+	announceModeration(admin, fmt.Sprintf("%s 被管理员 %s 禁言%s", userDisplayName(target), userDisplayName(admin), models.FormatDuration(duration)))
+	updatePresence(target.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// UnmuteUser 解除禁言
+func UnmuteUser(c *gin.Context) {
+	userID, ok := parseIDParam(c, "id", "用户ID无效")
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	target, err := models.UnmuteUser(admin.ID, userID)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+
+	announceModeration(admin, fmt.Sprintf("管理员 %s 解除了 %s 的禁言", userDisplayName(admin), userDisplayName(target)))
+	updatePresence(target.ID)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetAuditLog 获取最近的管理操作记录，可以用limit指定数量
+func GetAuditLog(c *gin.Context) {
+	limit, ok := parsePositiveInt(c, "limit")
+	if !ok {
+		return
+	}
+
+	entries, err := models.GetAuditLog(int(limit))
+	if err != nil {
+		log.Printf("获取管理操作记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取管理操作记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
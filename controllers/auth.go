@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+)
+
+// 会话Cookie名称
+const sessionCookieName = "chat_session"
+
+// 登录名规则：3-20位字母、数字或下划线
+var loginNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,20}$`)
+
+// 密码最小长度
+const minPasswordLength = 6
+
+// errNoSession 表示请求没有有效的会话
+var errNoSession = errors.New("没有有效的会话")
+
+// currentUser 根据会话Cookie获取当前用户，没有有效会话时返回errNoSession
+func currentUser(c *gin.Context) (*models.User, error) {
+	token, err := c.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, errNoSession
+	}
+	user, err := models.GetUserBySession(token)
+	if err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) {
+			return nil, errNoSession
+		}
+		return nil, err
+	}
+
+	// 记录用户当前使用的IP，IP变化不影响身份
+	if user.IP != c.ClientIP() {
+		if err := models.UpdateUserIP(user.ID, c.ClientIP()); err != nil {
+			log.Printf("更新用户IP失败: %v", err)
+		} else if refreshed, err := models.GetUserByID(user.ID); err == nil {
+			user = refreshed
+		}
+	}
+	return user, nil
+}
+
+// requireUser 获取当前用户，没有有效会话时写入401响应，出错时写入500响应，均返回false
+func requireUser(c *gin.Context) (*models.User, bool) {
+	user, err := currentUser(c)
+	if errors.Is(err, errNoSession) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "请先进入聊天室"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("获取用户信息失败: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "获取用户信息失败"})
+		return nil, false
+	}
+	return user, true
+}
+
+// guestUser 获取当前用户，没有有效会话时创建访客用户并签发会话
+//
+// 只有进入聊天室的入口（Enter和WebSocket连接）会创建访客，其他接口没有会话时返回401
+func guestUser(c *gin.Context) (*models.User, error) {
+	user, err := currentUser(c)
+	if !errors.Is(err, errNoSession) {
+		return user, err
+	}
+
+	// 创建访客用户
+	user, err = models.CreateUser(c.ClientIP(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := startSession(c, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// startSession 为用户创建会话并写入Cookie
+func startSession(c *gin.Context, userID int64) error {
+	session, err := models.CreateSession(userID)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		MaxAge:   int(models.SessionTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// GetCurrentUser 获取当前登录用户
+func GetCurrentUser(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Enter 进入聊天室，返回当前用户，没有会话时创建访客用户并签发会话
+func Enter(c *gin.Context) {
+	user, err := guestUser(c)
+	if err != nil {
+		log.Printf("创建访客用户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户信息失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// 登录和注册请求
+type credentials struct {
+	LoginName string `json:"login_name" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+}
+
+// Register 为当前访客用户设置登录名和密码
+func Register(c *gin.Context) {
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录名和密码不能为空"})
+		return
+	}
+
+	if !loginNamePattern.MatchString(req.LoginName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录名只能包含3-20位字母、数字或下划线"})
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码至少需要6位"})
+		return
+	}
+
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	user, err := models.RegisterUser(user.ID, req.LoginName, req.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrLoginNameTaken) || errors.Is(err, models.ErrAlreadyRegistered) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("注册用户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Login 使用登录名和密码登录
+func Login(c *gin.Context) {
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录名和密码不能为空"})
+		return
+	}
+
+	user, err := models.AuthenticateUser(req.LoginName, req.Password)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 废弃旧会话，签发新会话
+	if token, err := c.Cookie(sessionCookieName); err == nil {
+		models.DeleteSession(token)
+	}
+	if err := startSession(c, user.ID); err != nil {
+		log.Printf("创建会话失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Logout 退出登录
+func Logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookieName); err == nil {
+		if err := models.DeleteSession(token); err != nil {
+			log.Printf("删除会话失败: %v", err)
+		}
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:   sessionCookieName,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 获取会话Cookie对应的用户ID，没有有效会话时返回0，不会创建访客用户
+func sessionUserID(c *gin.Context) int64 {
+	token, err := c.Cookie(sessionCookieName)
+	if err != nil {
+		return 0
+	}
+	user, err := models.GetUserBySession(token)
+	if err != nil {
+		return 0
+	}
+	return user.ID
+}
+
+// 检查当前请求的用户和IP是否被封禁，被封禁时写入403响应并返回true
+func rejectBanned(c *gin.Context) bool {
+	ban, err := models.FindBan(sessionUserID(c), c.ClientIP())
+	if err != nil {
+		log.Printf("检查封禁状态失败: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "检查封禁状态失败"})
+		return true
+	}
+	if ban == nil {
+		return false
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":      "你已被封禁",
+		"reason":     ban.Reason,
+		"expires_at": ban.ExpiresAt,
+	})
+	return true
+}
+
+// RejectBanned 拒绝被封禁的用户和IP访问后续的处理函数
+func RejectBanned(c *gin.Context) {
+	if rejectBanned(c) {
+		return
+	}
+	c.Next()
+}
+
+// 断开所有适用于封禁的连接
+func disconnectBanned(ban *models.Ban) {
+	Hub.Disconnect(func(client *utils.Client) bool {
+		return ban.Matches(client.ID, client.IP)
+	}, &utils.Message{
+		Type:    utils.MessageTypeSystem,
+		Content: "你已被管理员封禁",
+	})
+}
+
+// 添加封禁请求，user_id和ip只能指定一个，ip可以是单个IP或CIDR网段
+type banRequest struct {
+	UserID int64  `json:"user_id"`
+	IP     string `json:"ip"`
+	moderationRequest
+}
+
+// GetBans 获取当前生效的封禁列表
+func GetBans(c *gin.Context) {
+	bans, err := models.GetActiveBans()
+	if err != nil {
+		log.Printf("获取封禁列表失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取封禁列表失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bans)
+}
+
+// CreateBan 按用户或IP添加封禁，并立即断开匹配的连接
+func CreateBan(c *gin.Context) {
+	var req banRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式无效"})
+		return
+	}
+	req.IP = strings.TrimSpace(req.IP)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if (req.UserID == 0) == (req.IP == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": models.ErrBanTarget.Error()})
+		return
+	}
+	duration, ok := parseDuration(c, req.Duration)
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	if req.UserID != 0 {
+		target, ban, err := models.BanUser(admin.ID, req.UserID, duration, req.Reason)
+		if err != nil {
+			respondModerationError(c, err)
+			return
+		}
+		disconnectBanned(ban)
+		announceModeration(admin, banAnnouncement(admin, target, duration, req.Reason))
+		c.JSON(http.StatusOK, ban)
+		return
+	}
+
+	ban, err := models.BanIP(admin.ID, c.ClientIP(), req.IP, duration, req.Reason)
+	if err != nil {
+		if errors.Is(err, models.ErrModerateSelf) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "封禁范围包含你当前使用的IP"})
+			return
+		}
+		respondModerationError(c, err)
+		return
+	}
+	disconnectBanned(ban)
+
+	c.JSON(http.StatusOK, ban)
+}
+
+// RemoveBan 解除封禁
+func RemoveBan(c *gin.Context) {
+	banID, ok := parseIDParam(c, "id", "封禁ID无效")
+	if !ok {
+		return
+	}
+
+	admin := currentAdmin(c)
+	ban, err := models.RemoveBan(admin.ID, banID)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+
+	if ban.UserID != 0 {
+		if target, err := models.GetUserByID(ban.UserID); err == nil {
+			announceModeration(admin, fmt.Sprintf("管理员 %s 解除了 %s 的封禁", userDisplayName(admin), userDisplayName(target)))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -1,14 +1,23 @@
 package controllers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 	
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/mikewang/go-gin-websocket-msg/config"
 	"github.com/mikewang/go-gin-websocket-msg/models"
 	"github.com/mikewang/go-gin-websocket-msg/utils"
 )
@@ -16,31 +25,60 @@ import (
 // Hub 是WebSocket hub的实例
 var Hub *utils.Hub
 
-// 活跃用户映射表，用于追踪实际在线的WebSocket连接
+// 服务端配置，包括连接心跳、消息大小、补发数量、自动离开和限流等设置，由Init设置
+var settings *config.Config
+
+// 活跃用户映射表，按用户追踪实际在线的WebSocket连接，同一用户可以同时有多个连接
 var (
-	activeUsers = make(map[int64]bool)
+	activeUsers = make(map[int64]map[*utils.Client]bool)
 	usersMutex  = &sync.Mutex{}
 )
 
-// 添加活跃用户
-func addActiveUser(userID int64) {
+// 添加活跃连接，返回是否为该用户的第一个连接
+func addActiveUser(client *utils.Client) bool {
 	usersMutex.Lock()
 	defer usersMutex.Unlock()
-	activeUsers[userID] = true
+	clients, exists := activeUsers[client.ID]
+	if !exists {
+		clients = make(map[*utils.Client]bool)
+		activeUsers[client.ID] = clients
+	}
+	clients[client] = true
+	return !exists
 }
 
-// 移除活跃用户
-func removeActiveUser(userID int64) {
+// 移除活跃连接，返回是否为该用户的最后一个连接
+func removeActiveUser(client *utils.Client) bool {
 	usersMutex.Lock()
 	defer usersMutex.Unlock()
-	delete(activeUsers, userID)
+	clients, exists := activeUsers[client.ID]
+	if !exists || !clients[client] {
+		return false
+	}
+	delete(clients, client)
+	if len(clients) > 0 {
+		return false
+	}
+	delete(activeUsers, client.ID)
+	return true
 }
 
 // 检查用户是否活跃
 func isUserActive(userID int64) bool {
 	usersMutex.Lock()
 	defer usersMutex.Unlock()
-	return activeUsers[userID]
+	return len(activeUsers[userID]) > 0
+}
+
+// 获取有WebSocket连接的用户ID
+func activeUserIDs() []int64 {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	ids := make([]int64, 0, len(activeUsers))
+	for userID := range activeUsers {
+		ids = append(ids, userID)
+	}
+	return ids
 }
 
 // 获取活跃用户数量
@@ -50,10 +88,63 @@ func getActiveUserCount() int {
 	return len(activeUsers)
 }
 
-// 初始化Hub
-func init() {
+// 用户的一个WebSocket连接
+type device struct {
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// 获取用户当前的所有连接，按连接时间从早到晚排列
+func userDevices(userID int64) []device {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	devices := make([]device, 0, len(activeUsers[userID]))
+	for client := range activeUsers[userID] {
+		devices = append(devices, device{
+			IP:          client.IP,
+			UserAgent:   client.UserAgent,
+			ConnectedAt: client.ConnectedAt,
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ConnectedAt.Before(devices[j].ConnectedAt)
+	})
+	return devices
+}
+
+// Init 根据配置初始化控制器依赖的Hub和文件存储
+func Init(cfg *config.Config) {
+	chatTitle = cfg.ChatTitle
+	settings = cfg
+	Files = utils.NewFileStore(cfg.UploadDir)
+	utils.Upgrader.CheckOrigin = utils.SameOrigin(cfg.AllowedOrigins)
+	
 	Hub = utils.NewHub()
 	go Hub.Run()
+	go watchIdleUsers()
+}
+
+// 保存大厅的系统消息后广播给所有客户端，消息ID用于客户端重连时指明收到的位置
+func broadcastSystemMessage(userID int64, content string) {
+	broadcastRoomSystemMessage(userID, models.DefaultRoomID, content)
+}
+
+// 保存房间的系统消息后广播给房间成员，大厅的系统消息广播给所有客户端
+func broadcastRoomSystemMessage(userID, roomID int64, content string) {
+	systemMsg := &utils.Message{
+		Type:    utils.MessageTypeSystem,
+		Content: content,
+	}
+	if roomID != models.DefaultRoomID {
+		systemMsg.RoomID = roomID
+	}
+	if saved, err := models.CreateMessage(userID, roomID, content, models.MessageTypeSystem); err != nil {
+		log.Printf("保存系统消息失败: %v", err)
+	} else {
+		systemMsg.MessageID = saved.ID
+	}
+	Hub.BroadcastMessage(systemMsg)
 }
 
 // HandleWebSocket 处理WebSocket连接
@@ -61,71 +152,130 @@ func HandleWebSocket(c *gin.Context) {
 	// 获取客户端IP
 	ip := c.ClientIP()
 	
-	// 尝试获取已有用户
-	user, err := models.GetUserByIP(ip)
-	if err != nil {
-		// 创建新用户
-		user, err = models.CreateUser(ip, "")
-		if err != nil {
-			log.Printf("创建用户失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建用户失败"})
+	// 被封禁的用户和IP不能连接
+	if rejectBanned(c) {
+		return
+	}
+	
+	// 重连的客户端通过since提供收到的最后一条消息ID，用于补发错过的消息
+	var since int64
+	if value := c.Query("since"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since参数无效"})
 			return
 		}
-		
-		// 广播用户进入聊天室的系统消息
-		systemMsg := &utils.Message{
-			Type:    utils.MessageTypeSystem,
-			Content: ip + " 进入了聊天室",
-		}
-		Hub.BroadcastMessage(systemMsg)
-		
-		// 保存系统消息到数据库
-		_, err = models.CreateMessage(user.ID, systemMsg.Content, models.MessageTypeSystem)
-		if err != nil {
-			log.Printf("保存系统消息失败: %v", err)
-		}
-	} else {
-		// 更新用户最后在线时间
-		err = models.UpdateLastOnline(user.ID)
-		if err != nil {
-			log.Printf("更新用户最后在线时间失败: %v", err)
+		since = id
+	}
+	
+	// 重连的客户端通过rooms提供之前加入的房间，未提供时加入默认房间
+	roomIDs := []int64{models.DefaultRoomID}
+	if value := c.Query("rooms"); value != "" {
+		ids, ok := parseRoomIDs(value)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rooms参数无效"})
+			return
 		}
+		roomIDs = existingRooms(ids)
+	}
+	
+	// 根据会话获取用户，没有会话时创建访客用户
+	user, err := guestUser(c)
+	if err != nil {
+		log.Printf("获取用户信息失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户信息失败"})
+		return
+	}
+	
+	// 更新用户最后在线时间
+	err = models.UpdateLastOnline(user.ID)
+	if err != nil {
+		log.Printf("更新用户最后在线时间失败: %v", err)
+	}
+	
+	// 新签发的会话Cookie需要随升级响应一起返回
+	responseHeader := http.Header{}
+	if cookies := c.Writer.Header().Values("Set-Cookie"); len(cookies) > 0 {
+		responseHeader["Set-Cookie"] = cookies
 	}
 	
 	// 升级HTTP连接为WebSocket连接
 	// 创建自定义的ServeWs处理函数，以便我们处理消息
-	conn, err := utils.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := utils.Upgrader.Upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	
 	client := &utils.Client{
-		ID:   user.ID,
-		IP:   ip,
-		Hub:  Hub,
-		Conn: conn,
-		Send: make(chan []byte, 256),
+		ID:          user.ID,
+		IP:          ip,
+		UserAgent:   c.Request.UserAgent(),
+		ConnectedAt: time.Now(),
+		Hub:         Hub,
+		Conn:        conn,
+		Send:        make(chan []byte, 256),
 	}
 	client.Hub.Register <- client
 	
-	// 添加到活跃用户列表
-	addActiveUser(user.ID)
+	// 加入房间，重连时回到之前加入的房间
+	for _, roomID := range roomIDs {
+		client.Hub.JoinRoom(client, roomID)
+	}
+	
+	// 已经加入广播后再获取错过的消息，补发期间的新消息在发送队列中等待，不会遗漏
+	var backlog [][]byte
+	if since != 0 {
+		backlog = replayMissed(client, roomIDs, since)
+	}
+	
+	// 添加到活跃用户列表，用户的第一个连接才广播进入聊天室的系统消息
+	if addActiveUser(client) {
+		broadcastSystemMessage(user.ID, userDisplayName(user)+" 进入了聊天室")
+		broadcastPresence(utils.MessageTypePresenceJoin, user)
+	} else {
+		broadcastPresence(utils.MessageTypePresenceUpdate, user)
+	}
+	touchActivity(user.ID)
+	
+	// 新连接收到一次完整的在线用户列表，之后只接收增量变化
+	sendPresenceSnapshot(client)
 	
 	// 启动goroutine来处理WebSocket连接
-	go handleWritePump(client)
+	go handleWritePump(client, backlog)
 	go handleReadPump(client)
 }
 
-// 处理WebSocket写入操作
-func handleWritePump(client *utils.Client) {
+// 判断读写错误是否由超时引起
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// 处理WebSocket写入操作，定时发送ping，写入超时的连接被关闭
+//
+// backlog是重连时补发的消息，合并为一帧先于发送队列中的消息写出
+func handleWritePump(client *utils.Client, backlog [][]byte) {
+	ticker := time.NewTicker(settings.PingInterval)
 	defer func() {
+		ticker.Stop()
 		client.Conn.Close()
 	}()
 	
+	if len(backlog) > 0 {
+		client.Conn.SetWriteDeadline(time.Now().Add(settings.WriteTimeout))
+		if err := client.Conn.WriteMessage(websocket.TextMessage, bytes.Join(backlog, []byte{'\n'})); err != nil {
+			if isTimeout(err) {
+				log.Printf("向用户 %d 补发消息超时，断开连接", client.ID)
+			}
+			return
+		}
+	}
+	
 	for {
 		select {
 		case message, ok := <-client.Send:
+			client.Conn.SetWriteDeadline(time.Now().Add(settings.WriteTimeout))
 			if !ok {
 				// 通道已关闭
 				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
@@ -146,6 +296,17 @@ func handleWritePump(client *utils.Client) {
 			}
 			
 			if err := w.Close(); err != nil {
+				if isTimeout(err) {
+					log.Printf("向用户 %d 写入消息超时，断开连接", client.ID)
+				}
+				return
+			}
+		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(settings.WriteTimeout))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				if isTimeout(err) {
+					log.Printf("向用户 %d 发送心跳超时，断开连接", client.ID)
+				}
 				return
 			}
 		}
@@ -155,21 +316,20 @@ func handleWritePump(client *utils.Client) {
 // 处理WebSocket读取操作
 func handleReadPump(client *utils.Client) {
 	defer func() {
-		// 用户断开连接时从活跃用户列表移除
-		removeActiveUser(client.ID)
-		
-		// 用户断开连接
-		systemMsg := &utils.Message{
-			Type:    utils.MessageTypeSystem,
-			Content: client.IP + " 离开了聊天室",
-		}
-		Hub.BroadcastMessage(systemMsg)
-		
-		// 保存系统消息到数据库
-		_, err := models.CreateMessage(client.ID, systemMsg.Content, models.MessageTypeSystem)
-		if err != nil {
-			log.Printf("保存系统消息失败: %v", err)
+		// 用户断开连接时从活跃用户列表移除，其他设备仍在线时不广播离开的系统消息
+		if removeActiveUser(client) {
+			clearActivity(client.ID)
+			broadcastSystemMessage(client.ID, displayName(client)+" 离开了聊天室")
+			Hub.BroadcastMessage(&utils.Message{
+				Type:   utils.MessageTypePresenceLeave,
+				UserID: client.ID,
+			})
+		} else {
+			// 其他设备仍在线，只更新设备列表
+			updatePresence(client.ID)
 		}
+		clearTyping(client)
+		clearRateLimits(client)
 		
 		// 更新用户最后在线时间
 		models.UpdateLastOnline(client.ID)
@@ -178,18 +338,36 @@ func handleReadPump(client *utils.Client) {
 		client.Conn.Close()
 	}()
 	
+	// 超出大小的消息会使连接以1009（消息过大）关闭
+	client.Conn.SetReadLimit(settings.MaxFrameSize)
+	
+	// 超过心跳超时没有收到pong或消息时视为连接已断开，收到pong时同时刷新在线时间
+	client.Conn.SetReadDeadline(time.Now().Add(settings.PongTimeout))
+	client.Conn.SetPongHandler(func(string) error {
+		if err := models.UpdateLastOnline(client.ID); err != nil {
+			log.Printf("更新用户最后在线时间失败: %v", err)
+		}
+		return client.Conn.SetReadDeadline(time.Now().Add(settings.PongTimeout))
+	})
+	
 	for {
 		_, message, err := client.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("用户 %d 发送的消息超过%d字节，断开连接", client.ID, settings.MaxFrameSize)
+			} else if isTimeout(err) {
+				log.Printf("用户 %d 的连接超过%s没有响应，断开连接", client.ID, settings.PongTimeout)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("错误: %v", err)
 			}
 			break
 		}
+		client.Conn.SetReadDeadline(time.Now().Add(settings.PongTimeout))
 		
 		var msg utils.Message
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Printf("错误: 解析消息失败: %v", err)
+			sendError(client, "", "", errCodeInvalidMessage, "消息格式无效")
 			continue
 		}
 		
@@ -197,6 +375,11 @@ func handleReadPump(client *utils.Client) {
 		msg.UserID = client.ID
 		msg.IP = client.IP
 		
+		// 文件名、大小和消息状态以服务端记录为准，不使用客户端提供的值
+		msg.FileName = ""
+		msg.FileSize = 0
+		msg.Status = 0
+		
 		// 处理消息
 		HandleMessage(client, &msg)
 	}
@@ -206,39 +389,91 @@ func handleReadPump(client *utils.Client) {
 func HandleMessage(client *utils.Client, msg *utils.Message) {
 	var err error
 	
+	// 请求ID只用于回复发送者，不随消息转发
+	requestID := msg.RequestID
+	msg.RequestID = ""
+	
+	// 限流，输入状态另行节流，已读回执使用单独的限流器，超出时直接丢弃
+	allowed := true
+	switch msg.Type {
+	case utils.MessageTypeTyping:
+	case utils.MessageTypeRead:
+		if !allowClient(client, limitRead) {
+			return
+		}
+	case utils.MessageTypeUser:
+		allowed = allowClient(client, limitRename)
+	default:
+		allowed = allowClient(client, limitMessage)
+	}
+	if !allowed {
+		sendError(client, msg.Type, requestID, errCodeRateLimited, "操作过于频繁，消息未处理")
+		return
+	}
+	
+	// 除自动发送的已读回执外，收到消息说明用户正在操作
+	if msg.Type != utils.MessageTypeRead {
+		touchActivity(client.ID)
+	}
+	
 	// 根据消息类型处理消息
 	switch msg.Type {
-	case utils.MessageTypeText, utils.MessageTypeImage, utils.MessageTypeEmoji:
+	case utils.MessageTypeText, utils.MessageTypeEmoji:
 		err = handleChatMessage(client, msg)
-	case utils.MessageTypeFile:
+		stopTyping(client, msg.RoomID, 0)
+	case utils.MessageTypeImage, utils.MessageTypeFile:
 		err = handleFileMessage(client, msg)
+		stopTyping(client, msg.RoomID, 0)
+	case utils.MessageTypeDirect:
+		err = handleDirectMessage(client, msg)
+		stopTyping(client, 0, msg.TargetID)
 	case utils.MessageTypeRecall:
 		err = handleRecallMessage(client, msg)
+	case utils.MessageTypeEdit:
+		err = handleEditMessage(client, msg)
+	case utils.MessageTypeReaction:
+		err = handleReaction(client, msg)
+	case utils.MessageTypeRead:
+		err = handleRead(client, msg)
+	case utils.MessageTypeTyping:
+		err = handleTyping(client, msg)
+	case utils.MessageTypeRoomJoin:
+		err = handleRoomJoin(client, msg)
+	case utils.MessageTypeRoomLeave:
+		err = handleRoomLeave(client, msg)
 	case utils.MessageTypeUser:
-		handleUserUpdate(client, msg)
-		return
+		err = handleUserUpdate(client, msg)
+	case utils.MessageTypePresence:
+		err = handlePresenceMessage(client, msg)
 	default:
-		log.Printf("未知的消息类型: %s", msg.Type)
-		return
+		err = errUnknownType
 	}
 	
 	if err != nil {
-		log.Printf("处理消息失败: %v", err)
+		log.Printf("处理用户 %d 的 %s 消息失败: %v", client.ID, msg.Type, err)
+		replyError(client, msg, requestID, err)
+		return
+	}
+	
+	// 客户端提供了请求ID时确认消息已处理
+	if requestID != "" {
+		sendAck(client, msg, requestID)
 	}
 }
 
-// 处理聊天消息（文本、图片和表情）
+// 处理聊天消息（文本和表情）
 func handleChatMessage(client *utils.Client, msg *utils.Message) error {
 	// 获取用户信息
-	user, err := models.GetUserByIP(client.IP)
+	user, err := models.GetUserByID(client.ID)
 	if err != nil {
 		log.Printf("获取用户信息失败: %v", err)
 		return err
 	}
 	
-	// 设置消息发送者信息
-	msg.UserID = user.ID
-	msg.Username = user.UsernameStr
+	// 检查房间成员关系
+	if err := checkRoomMember(client, msg); err != nil {
+		return err
+	}
 	
 	// 保存消息到数据库
 	var msgType int
@@ -257,7 +492,7 @@ func handleChatMessage(client *utils.Client, msg *utils.Message) error {
 		msgType = models.MessageTypeText
 	}
 	
-	dbMsg, err := models.CreateMessage(user.ID, msg.Content, msgType)
+	dbMsg, err := models.CreateReply(user.ID, msg.RoomID, msg.Content, msgType, msg.ReplyTo)
 	if err != nil {
 		log.Printf("保存消息失败: %v", err)
 		return err
@@ -266,27 +501,42 @@ func handleChatMessage(client *utils.Client, msg *utils.Message) error {
 	// 设置消息ID，便于后续撤回
 	msg.MessageID = dbMsg.ID
 	
-	// 广播消息
-	client.Hub.BroadcastMessage(msg)
+	// 按保存的消息重新构造广播内容，客户端提供的其他字段不转发
+	client.Hub.BroadcastMessage(liveMessage(dbMsg))
+	
+	// 单独通知被@提到的用户
+	notifyMentions(client, user, dbMsg)
 	
 	return nil
 }
 
-// 处理文件消息
+// 处理图片和文件消息，消息只携带已上传文件的引用
 func handleFileMessage(client *utils.Client, msg *utils.Message) error {
+	file, err := models.GetFileByID(msg.FileID)
+	if err != nil {
+		return err
+	}
+	
+	// 图片类型和文件的上传者由models.CreateFileMessage校验
+	msgType := models.MessageTypeFile
+	if msg.Type == utils.MessageTypeImage {
+		msgType = models.MessageTypeImage
+	}
+	
 	// 获取用户信息
-	user, err := models.GetUserByIP(client.IP)
+	user, err := models.GetUserByID(client.ID)
 	if err != nil {
 		log.Printf("获取用户信息失败: %v", err)
 		return err
 	}
 	
-	// 设置消息发送者信息
-	msg.UserID = user.ID
-	msg.Username = user.UsernameStr
+	// 检查房间成员关系
+	if err := checkRoomMember(client, msg); err != nil {
+		return err
+	}
 	
-	// 保存消息到数据库
-	dbMsg, err := models.CreateFileMessage(user.ID, msg.Content, msg.FileName, msg.FileSize)
+	// 保存消息到数据库，文件名和大小以服务端记录为准
+	dbMsg, err := models.CreateFileMessage(user.ID, msg.RoomID, msgType, file, msg.ReplyTo)
 	if err != nil {
 		log.Printf("保存文件消息失败: %v", err)
 		return err
@@ -295,76 +545,82 @@ func handleFileMessage(client *utils.Client, msg *utils.Message) error {
 		msg.MessageID = dbMsg.ID
 	}
 	
-	// 广播消息给所有客户端
-	Hub.BroadcastMessage(msg)
+	// 按保存的消息重新构造广播内容，客户端提供的其他字段不转发
+	Hub.BroadcastMessage(liveMessage(dbMsg))
 	return nil
 }
 
 // 处理消息撤回
 func handleRecallMessage(client *utils.Client, msg *utils.Message) error {
 	if msg.MessageID == 0 {
-		return nil
+		return errMissingMessageID
 	}
 	
-	// 撤回消息
-	err := models.RecallMessage(msg.MessageID, client.ID)
+	// 获取原消息信息，用于确定通知范围
+	original, err := models.GetMessageByID(msg.MessageID)
 	if err != nil {
-		log.Printf("撤回消息失败: %v", err)
 		return err
 	}
 	
-	// 获取原消息信息，确认消息可以被撤回
-	_, err = models.GetMessageByID(msg.MessageID)
+	// 撤回消息，撤回成功后的通知失败只记录日志，不再向发送者报告撤回失败
+	err = models.RecallMessage(msg.MessageID, client.ID)
 	if err != nil {
-		log.Printf("获取原消息失败: %v", err)
+		log.Printf("撤回消息失败: %v", err)
 		return err
 	}
 	
-	// 创建撤回通知消息
+	// 创建撤回通知消息，只通知原消息所在房间
 	recallNotice := &utils.Message{
 		Type:      utils.MessageTypeRecall,
 		MessageID: msg.MessageID,
 		UserID:    client.ID,
-		Username:  client.IP, // 使用IP作为默认用户名
+		Username:  displayName(client),
+		RoomID:    original.RoomID,
 	}
 	
-	// 如果能获取到用户信息，则使用用户名
-	user, err := models.GetUserByIP(client.IP)
-	if err == nil && user.UsernameStr != "" {
-		recallNotice.Username = user.UsernameStr
+	// 私信撤回只通知会话双方，不记录系统消息
+	if original.RecipientID != 0 {
+		recallNotice.TargetID = original.RecipientID
+		Hub.SendToUsers([]int64{original.UserID, original.RecipientID}, recallNotice)
+		return nil
 	}
 	
-	// 广播撤回通知给所有客户端
+	// 广播撤回通知给房间成员
 	Hub.BroadcastMessage(recallNotice)
 	
-	// 记录系统消息
-	systemMsg := fmt.Sprintf("%s 撤回了一条消息", recallNotice.Username)
-	_, err = models.CreateMessage(client.ID, systemMsg, models.MessageTypeSystem)
-	if err != nil {
-		log.Printf("保存撤回系统消息失败: %v", err)
-		return err
-	}
+	// 保存并广播系统消息，与其他系统消息一样带有消息ID，重连补发时与在线客户端看到的一致
+	broadcastRoomSystemMessage(client.ID, original.RoomID, fmt.Sprintf("%s 撤回了一条消息", recallNotice.Username))
 	return nil
 }
 
+// 昵称的最大字符数
+const maxUsernameLength = 20
+
 // 处理用户信息更新
-func handleUserUpdate(client *utils.Client, msg *utils.Message) {
+func handleUserUpdate(client *utils.Client, msg *utils.Message) error {
+	msg.Username = strings.TrimSpace(msg.Username)
 	if msg.Username == "" {
-		return
+		return errEmptyUsername
+	}
+	if utf8.RuneCountInString(msg.Username) > maxUsernameLength {
+		return fmt.Errorf("%w，最多%d个字符", errUsernameTooLong, maxUsernameLength)
 	}
 	
+	// 记录修改前的显示名称
+	oldName := displayName(client)
+	
 	// 更新用户名
 	err := models.UpdateUsername(client.ID, msg.Username)
 	if err != nil {
 		log.Printf("更新用户名失败: %v", err)
-		return
+		return err
 	}
 	
 	// 获取更新后的用户信息
-	user, err := models.GetUserByIP(client.IP)
+	user, err := models.GetUserByID(client.ID)
 	if err != nil {
 		log.Printf("获取用户信息失败: %v", err)
-		return
+		return err
 	}
 	
 	// 发送用户信息更新消息给当前用户
@@ -376,90 +632,175 @@ func handleUserUpdate(client *utils.Client, msg *utils.Message) {
 	client.Hub.BroadcastMessage(updateMsg)
 	
 	// 广播用户名更新的系统消息
-	systemMsg := &utils.Message{
-		Type:    utils.MessageTypeSystem,
-		Content: fmt.Sprintf("%s 将昵称修改为 %s", client.IP, msg.Username),
-	}
-	client.Hub.BroadcastMessage(systemMsg)
+	broadcastSystemMessage(client.ID, fmt.Sprintf("%s 将昵称修改为 %s", oldName, msg.Username))
 	
-	// 保存系统消息到数据库
-	_, err = models.CreateMessage(client.ID, systemMsg.Content, models.MessageTypeSystem)
-	if err != nil {
-		log.Printf("保存系统消息失败: %v", err)
+	// 通知所有人该用户的昵称变化
+	broadcastPresence(utils.MessageTypePresenceUpdate, user)
+	return nil
+}
+
+// GetMessages 分页获取房间历史消息，通过room参数指定房间，默认为大厅
+//
+// before=<id>获取该消息之前的消息，after=<id>获取该消息之后的消息，都不指定时获取最新的消息；
+// limit指定每页数量。响应中的next_cursor用于继续向同一方向翻页，没有更多消息时为null
+func GetMessages(c *gin.Context) {
+	roomID := models.DefaultRoomID
+	if room := c.Query("room"); room != "" {
+		id, err := strconv.ParseInt(room, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "房间ID无效"})
+			return
+		}
+		roomID = id
 	}
 	
-	// 获取最新的在线用户列表
-	users, err := models.GetOnlineUsers()
-	if err != nil {
-		log.Printf("获取在线用户失败: %v", err)
+	if _, err := models.GetRoomByID(roomID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "房间不存在"})
 		return
 	}
 	
-	// 过滤非真正活跃的用户
-	activeUsersList := make([]*models.User, 0)
-	for _, u := range users {
-		if isUserActive(u.ID) {
-			activeUsersList = append(activeUsersList, u)
-		}
+	page, ok := parsePage(c)
+	if !ok {
+		return
 	}
 	
-	// 广播更新后的在线用户列表
-	usersMsg := &utils.Message{
-		Type: utils.MessageTypeUsers,
-		Data: activeUsersList,
+	messages, cursor, err := models.GetMessagePage(roomID, page)
+	if errors.Is(err, models.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	client.Hub.BroadcastMessage(usersMsg)
-}
-
-// GetMessages 获取历史消息
-func GetMessages(c *gin.Context) {
-	// 默认获取最近100条消息
-	messages, err := models.GetMessages(100)
 	if err != nil {
 		log.Printf("获取消息失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取消息失败"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, messages)
+	respondPage(c, messages, cursor)
 }
 
-// SearchMessages 搜索聊天历史消息
-func SearchMessages(c *gin.Context) {
-	query := c.Query("q")
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "搜索关键字不能为空"})
+// 解析before、after和limit分页参数
+func parsePage(c *gin.Context) (models.Page, bool) {
+	var page models.Page
+	for name, value := range map[string]*int64{"before": &page.Before, "after": &page.After} {
+		if param := c.Query(name); param != "" {
+			id, err := strconv.ParseInt(param, 10, 64)
+			if err != nil || id <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + "参数无效"})
+				return page, false
+			}
+			*value = id
+		}
+	}
+	if param := c.Query("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit参数无效"})
+			return page, false
+		}
+		page.Limit = limit
+	}
+	return page, true
+}
+
+// 返回一页消息，没有更多消息时next_cursor为null
+func respondPage(c *gin.Context, messages []*models.Message, cursor int64) {
+	var nextCursor interface{}
+	if cursor != 0 {
+		nextCursor = cursor
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    messages,
+		"next_cursor": nextCursor,
+	})
+}
+
+// GetThread 获取消息及其回复，私信只有会话双方可以查看
+func GetThread(c *gin.Context) {
+	messageID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "消息ID无效"})
 		return
 	}
 	
-	messages, err := models.SearchMessages(query)
+	parent, replies, err := models.GetThread(messageID)
+	if errors.Is(err, models.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+		return
+	}
 	if err != nil {
-		log.Printf("搜索消息失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索消息失败"})
+		log.Printf("获取回复失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取回复失败"})
+		return
+	}
+	
+	if !checkMessageAccess(c, parent) {
 		return
 	}
 	
-	c.JSON(http.StatusOK, messages)
+	c.JSON(http.StatusOK, gin.H{
+		"message": parent,
+		"replies": replies,
+	})
+}
+
+// 检查当前用户能否查看消息，私信只有会话双方可以查看，不能查看时直接返回错误响应
+func checkMessageAccess(c *gin.Context, msg *models.Message) bool {
+	if msg.RecipientID == 0 {
+		return true
+	}
+	
+	user, ok := requireUser(c)
+	if !ok {
+		return false
+	}
+	
+	// 对非会话双方隐藏私信是否存在
+	if user.ID != msg.UserID && user.ID != msg.RecipientID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+		return false
+	}
+	return true
+}
+
+// 在线用户及其当前的所有连接
+type onlineUser struct {
+	*models.User
+	Presence string   `json:"presence"` // 实际显示的在线状态，覆盖用户设置的状态
+	Idle     bool     `json:"idle"`     // 是否因空闲自动显示为离开
+	Devices  []device `json:"devices"`
+}
+
+// 获取有WebSocket连接的用户，按最早的连接时间排列
+//
+// 只根据连接判断是否在线，与presence_join和presence_leave使用相同的依据
+func activeOnlineUsers() ([]*onlineUser, error) {
+	activeUsersList := make([]*onlineUser, 0)
+	for _, userID := range activeUserIDs() {
+		user, err := models.GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		// 获取用户信息期间连接可能已经断开
+		if devices := userDevices(userID); len(devices) > 0 {
+			activeUsersList = append(activeUsersList, newOnlineUser(user, devices))
+		}
+	}
+	sort.Slice(activeUsersList, func(i, j int) bool {
+		return activeUsersList[i].Devices[0].ConnectedAt.Before(activeUsersList[j].Devices[0].ConnectedAt)
+	})
+	return activeUsersList, nil
 }
 
 // GetOnlineUsers 获取在线用户列表
 func GetOnlineUsers(c *gin.Context) {
-	users, err := models.GetOnlineUsers()
+	users, err := activeOnlineUsers()
 	if err != nil {
 		log.Printf("获取在线用户失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取在线用户失败"})
 		return
 	}
 	
-	// 过滤非真正活跃的用户
-	activeUsersList := make([]*models.User, 0)
-	for _, user := range users {
-		if isUserActive(user.ID) {
-			activeUsersList = append(activeUsersList, user)
-		}
-	}
-	
-	c.JSON(http.StatusOK, activeUsersList)
+	c.JSON(http.StatusOK, users)
 }
 
 // GetStatistics 获取聊天室统计信息
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 处理一对一私信
+func handleDirectMessage(client *utils.Client, msg *utils.Message) error {
+	if msg.TargetID == 0 || msg.TargetID == client.ID {
+		return errInvalidRecipient
+	}
+
+	// 确认接收者存在
+	if _, err := models.GetUserByID(msg.TargetID); err != nil {
+		return errRecipientNotFound
+	}
+
+	// 获取用户信息
+	user, err := models.GetUserByID(client.ID)
+	if err != nil {
+		log.Printf("获取用户信息失败: %v", err)
+		return err
+	}
+
+	dbMsg, err := models.CreateDirectMessage(user.ID, msg.TargetID, msg.Content, msg.ReplyTo)
+	if err != nil {
+		log.Printf("保存私信失败: %v", err)
+		return err
+	}
+	msg.MessageID = dbMsg.ID
+
+	// 只投递给发送者和接收者的连接，内容按保存的消息重新构造
+	client.Hub.SendToUsers([]int64{user.ID, msg.TargetID}, liveMessage(dbMsg))
+
+	return nil
+}
+
+// GetConversation 获取当前用户与指定用户之间的私信记录
+func GetConversation(c *gin.Context) {
+	peerID, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID无效"})
+		return
+	}
+
+	// 当前用户总是会话的一方，因此只有双方能读取会话
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	if _, err := models.GetUserByID(peerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	messages, err := models.GetConversation(user.ID, peerID, 100)
+	if err != nil {
+		log.Printf("获取私信记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取私信记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
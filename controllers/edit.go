@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 处理消息编辑，编辑后通知能看到该消息的客户端原地替换内容
+func handleEditMessage(client *utils.Client, msg *utils.Message) error {
+	if msg.MessageID == 0 {
+		return errMissingMessageID
+	}
+
+	edited, err := models.EditMessage(msg.MessageID, client.ID, msg.Content)
+	if err != nil {
+		log.Printf("编辑消息失败: %v", err)
+		return err
+	}
+
+	notice := &utils.Message{
+		Type:      utils.MessageTypeEdit,
+		MessageID: edited.ID,
+		Content:   edited.Content,
+		UserID:    client.ID,
+		Username:  displayName(client),
+		RoomID:    edited.RoomID,
+		Data: map[string]interface{}{
+			"edited_at": edited.EditedAt,
+		},
+	}
+
+	// 私信编辑只通知会话双方
+	if edited.RecipientID != 0 {
+		notice.TargetID = edited.RecipientID
+		client.Hub.SendToUsers([]int64{edited.UserID, edited.RecipientID}, notice)
+		return nil
+	}
+
+	client.Hub.BroadcastMessage(notice)
+	return nil
+}
+
+// GetMessageEdits 获取消息的编辑历史
+func GetMessageEdits(c *gin.Context) {
+	messageID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "消息ID无效"})
+		return
+	}
+
+	msg, err := models.GetMessageByID(messageID)
+	if errors.Is(err, models.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+		return
+	}
+	if err != nil {
+		log.Printf("获取消息失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取消息失败"})
+		return
+	}
+	if !checkMessageAccess(c, msg) {
+		return
+	}
+
+	edits, err := models.GetMessageEdits(messageID)
+	if errors.Is(err, models.ErrEditsRecalled) {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("获取编辑历史失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取编辑历史失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": msg,
+		"edits":   edits,
+	})
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 发送给客户端的错误码，供客户端程序判断被拒绝的原因
+const (
+	errCodeInvalidMessage = "invalid_message"  // 消息格式无效或缺少必要的字段
+	errCodeUnknownType    = "unknown_type"     // 不支持的消息类型
+	errCodeEmptyContent   = "empty_content"    // 内容为空
+	errCodeTooLong        = "content_too_long" // 内容超过最大长度
+	errCodeNotImage       = "invalid_image"    // 文件不是允许发送的图片
+	errCodeFileNotFound   = "file_not_found"   // 引用的文件不存在
+	errCodeNotFound       = "not_found"        // 消息、房间或用户不存在
+	errCodeForbidden      = "forbidden"        // 无权操作他人的消息或引用他人上传的文件
+	errCodeNotMember      = "not_room_member"  // 未加入目标房间
+	errCodeExpired        = "expired"          // 超过可撤回或可编辑的时间
+	errCodeNotAllowed     = "not_allowed"      // 消息当前的状态不允许该操作
+	errCodeMuted          = "muted"            // 发送者被禁言
+	errCodeRateLimited    = "rate_limited"     // 发送过于频繁，消息被丢弃
+	errCodeInternal       = "internal_error"   // 服务端错误，详细原因只记录在日志中
+)
+
+// 处理WebSocket消息时的错误
+var (
+	errMissingMessageID   = errors.New("缺少消息ID")
+	errInvalidRecipient   = errors.New("私信接收者无效")
+	errRecipientNotFound  = errors.New("私信接收者不存在")
+	errNotRoomMember      = errors.New("未加入该房间")
+	errReactionNotAllowed = errors.New("该消息不能添加表情回应")
+	errReactionForbidden  = errors.New("不能回应其他人的私信")
+	errEmptyUsername      = errors.New("昵称不能为空")
+	errUsernameTooLong    = errors.New("昵称过长")
+	errUnknownType        = errors.New("未知的消息类型")
+)
+
+// 可以告知发送者的错误及其错误码，其他错误按internal_error回复，原因只记录在服务端日志中
+var clientErrors = []struct {
+	err  error
+	code string
+}{
+	{errMissingMessageID, errCodeInvalidMessage},
+	{errInvalidRecipient, errCodeInvalidMessage},
+	{models.ErrInvalidReaction, errCodeInvalidMessage},
+	{models.ErrInvalidPresence, errCodeInvalidMessage},
+	{errUnknownType, errCodeUnknownType},
+	{models.ErrEmptyContent, errCodeEmptyContent},
+	{models.ErrEditEmpty, errCodeEmptyContent},
+	{errEmptyUsername, errCodeEmptyContent},
+	{models.ErrContentTooLong, errCodeTooLong},
+	{models.ErrStatusTooLong, errCodeTooLong},
+	{errUsernameTooLong, errCodeTooLong},
+	{models.ErrNotImage, errCodeNotImage},
+	{models.ErrFileNotFound, errCodeFileNotFound},
+	{models.ErrMessageNotFound, errCodeNotFound},
+	{models.ErrRoomNotFound, errCodeNotFound},
+	{errRecipientNotFound, errCodeNotFound},
+	{models.ErrRecallForbidden, errCodeForbidden},
+	{models.ErrEditForbidden, errCodeForbidden},
+	{models.ErrFileForbidden, errCodeForbidden},
+	{errReactionForbidden, errCodeForbidden},
+	{errNotRoomMember, errCodeNotMember},
+	{models.ErrRecallExpired, errCodeExpired},
+	{models.ErrEditExpired, errCodeExpired},
+	{models.ErrEditRecalled, errCodeNotAllowed},
+	{models.ErrRecallSystem, errCodeNotAllowed},
+	{models.ErrAlreadyRecalled, errCodeNotAllowed},
+	{models.ErrEditUnchanged, errCodeNotAllowed},
+	{errReactionNotAllowed, errCodeNotAllowed},
+	{models.ErrReplyRecalled, errCodeNotAllowed},
+	{models.ErrReplyOutOfScope, errCodeNotAllowed},
+	{models.ErrReadOutOfScope, errCodeNotAllowed},
+	{models.ErrMuted, errCodeMuted},
+}
+
+// 把消息处理失败的原因发送给发送者，未列出的错误不向客户端透露详细原因
+func replyError(client *utils.Client, msg *utils.Message, requestID string, err error) {
+	for _, e := range clientErrors {
+		if errors.Is(err, e.err) {
+			sendError(client, msg.Type, requestID, e.code, err.Error())
+			return
+		}
+	}
+	sendError(client, msg.Type, requestID, errCodeInternal, "处理消息失败，请稍后重试")
+}
+
+// 向发送者发送错误消息，request_id是客户端在原消息中提供的请求ID，data中的type是原消息的类型
+func sendError(client *utils.Client, msgType, requestID, code, content string) {
+	client.Hub.SendToClient(client, &utils.Message{
+		Type:      utils.MessageTypeError,
+		Content:   content,
+		RequestID: requestID,
+		Data: map[string]interface{}{
+			"code": code,
+			"type": msgType,
+		},
+	})
+}
+
+// 确认消息已处理，message_id是保存后的消息ID或被操作的消息ID
+func sendAck(client *utils.Client, msg *utils.Message, requestID string) {
+	client.Hub.SendToClient(client, &utils.Message{
+		Type:      utils.MessageTypeAck,
+		MessageID: msg.MessageID,
+		RequestID: requestID,
+		Data: map[string]interface{}{
+			"type": msg.Type,
+		},
+	})
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+const (
+	maxUploadSize  = 10 << 20         // 上传文件大小上限（10MB）
+	maxImagePixels = 50 * 1000 * 1000 // 图片的最大像素数，超出时按普通文件处理
+)
+
+// Files 是上传文件的磁盘存储，由Init根据配置创建
+var Files *utils.FileStore
+
+// UploadFile 处理multipart文件上传，返回文件ID供图片和文件消息引用
+func UploadFile(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	if !allowUpload(c, user.ID) {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的文件"})
+		return
+	}
+	if header.Size > maxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文件大小不能超过%dMB", maxUploadSize>>20)})
+		return
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		log.Printf("读取上传文件失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+
+	// 根据文件内容识别类型，不信任客户端声明的类型
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(src, sniff)
+	mimeType := http.DetectContentType(sniff[:n])
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		log.Printf("读取上传文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+
+	// 不允许的图片类型或无法解码的图片按普通文件保存，不能作为图片消息发送和在页面中显示
+	if strings.HasPrefix(mimeType, "image/") {
+		if !validImage(src, mimeType) {
+			mimeType = "application/octet-stream"
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			log.Printf("读取上传文件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取上传文件失败"})
+			return
+		}
+	}
+
+	hash, size, err := Files.Save(src)
+	if err != nil {
+		log.Printf("保存上传文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+
+	file, err := models.CreateFile(hash, filepath.Base(header.Filename), mimeType, size, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":        file.ID,
+		"name":      file.Name,
+		"size":      file.Size,
+		"mime_type": file.MimeType,
+		"url":       fmt.Sprintf("/api/files/%d", file.ID),
+	})
+}
+
+// 检查图片是否为允许的类型，并且文件头能按该类型解码
+func validImage(r io.Reader, mimeType string) bool {
+	if !models.IsImageType(mimeType) {
+		return false
+	}
+	config, format, err := image.DecodeConfig(r)
+	if err != nil || "image/"+format != mimeType {
+		return false
+	}
+	return config.Width > 0 && config.Height > 0 && int64(config.Width)*int64(config.Height) <= maxImagePixels
+}
+
+// DownloadFile 下载文件，支持Range请求
+func DownloadFile(c *gin.Context) {
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件ID无效"})
+		return
+	}
+
+	file, err := models.GetFileByID(fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+
+	// 消息被撤回或删除后，其中的文件不能再下载
+	allowed, err := models.CanDownloadFile(file, sessionUserID(c))
+	if err != nil {
+		log.Printf("检查文件 %d 的引用失败: %v", file.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取文件失败"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+
+	blob, err := Files.Open(file.Hash)
+	if err != nil {
+		log.Printf("打开文件 %d 失败: %v", file.ID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+	defer blob.Close()
+
+	// 图片在页面中直接显示，其他文件作为附件下载
+	disposition := "attachment"
+	if models.IsImageType(file.MimeType) {
+		disposition = "inline"
+	}
+
+	// 禁止浏览器忽略Content-Type猜测内容类型，避免普通文件被当作HTML或脚本执行
+	c.Header("Content-Type", file.MimeType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Name}))
+	c.Header("ETag", `"`+file.Hash+`"`)
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+
+	http.ServeContent(c.Writer, c.Request, file.Name, file.CreatedAt, blob)
+}
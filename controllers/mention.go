@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 记录消息中@提到的用户，并只向这些用户的连接发送提及通知
+//
+// 通知不设置RoomID，被提到的用户在其他房间时也能收到
+func notifyMentions(client *utils.Client, author *models.User, msg *models.Message) {
+	mentioned, err := models.CreateMentions(msg)
+	if err != nil {
+		log.Printf("保存提及记录失败: %v", err)
+		return
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+
+	roomName := ""
+	if room, err := models.GetRoomByID(msg.RoomID); err == nil {
+		roomName = room.Name
+	}
+
+	userIDs := make([]int64, len(mentioned))
+	for i, user := range mentioned {
+		userIDs[i] = user.ID
+	}
+	client.Hub.SendToUsers(userIDs, &utils.Message{
+		Type:      utils.MessageTypeMention,
+		MessageID: msg.ID,
+		Content:   msg.Content,
+		UserID:    author.ID,
+		Username:  userDisplayName(author),
+		Data: map[string]interface{}{
+			"room_id":   msg.RoomID,
+			"room_name": roomName,
+		},
+	})
+}
+
+// GetMentions 分页获取提到当前用户的消息，分页参数和响应格式与GetMessages相同
+func GetMentions(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	messages, cursor, err := models.GetMentions(user.ID, page)
+	if errors.Is(err, models.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("获取提及消息失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取提及消息失败"})
+		return
+	}
+
+	respondPage(c, messages, cursor)
+}
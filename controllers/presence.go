@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 检查空闲用户的间隔
+const idleCheckInterval = 10 * time.Second
+
+// 在线用户最近一次操作的时间，以及超过空闲时间的用户，只保存在内存中
+var (
+	lastActivity  = make(map[int64]time.Time)
+	idleUsers     = make(map[int64]bool)
+	presenceMutex = &sync.Mutex{}
+)
+
+// 用户实际显示的在线状态，设置为在线但空闲的用户显示为离开
+func effectivePresence(user *models.User) string {
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+	if user.Presence == models.PresenceOnline && idleUsers[user.ID] {
+		return models.PresenceAway
+	}
+	return user.Presence
+}
+
+// 在线用户列表中的一项，包括实际显示的在线状态和设备列表
+func newOnlineUser(user *models.User, devices []device) *onlineUser {
+	presence := effectivePresence(user)
+	return &onlineUser{
+		User:     user,
+		Presence: presence,
+		Idle:     presence != user.Presence,
+		Devices:  devices,
+	}
+}
+
+// 向所有客户端广播在线用户的变化，msgType为presence_join或presence_update
+func broadcastPresence(msgType string, user *models.User) {
+	Hub.BroadcastMessage(&utils.Message{
+		Type:   msgType,
+		UserID: user.ID,
+		Data:   newOnlineUser(user, userDevices(user.ID)),
+	})
+}
+
+// 用户信息变化后通知所有人，用户不在线时不通知
+func updatePresence(userID int64) {
+	if !isUserActive(userID) {
+		return
+	}
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		log.Printf("获取用户信息失败: %v", err)
+		return
+	}
+	broadcastPresence(utils.MessageTypePresenceUpdate, user)
+}
+
+// 向新连接发送完整的在线用户列表
+func sendPresenceSnapshot(client *utils.Client) {
+	users, err := activeOnlineUsers()
+	if err != nil {
+		log.Printf("获取在线用户失败: %v", err)
+		return
+	}
+	Hub.SendToClient(client, &utils.Message{
+		Type: utils.MessageTypeUsers,
+		Data: users,
+	})
+}
+
+// 处理设置在线状态的消息，presence为在线状态，content为自定义状态文字
+func handlePresenceMessage(client *utils.Client, msg *utils.Message) error {
+	user, err := models.SetPresence(client.ID, msg.Presence, msg.Content)
+	if err != nil {
+		return err
+	}
+
+	broadcastPresence(utils.MessageTypePresenceUpdate, user)
+	return nil
+}
+
+// 记录用户的操作，因空闲显示为离开的用户恢复为在线
+func touchActivity(userID int64) {
+	presenceMutex.Lock()
+	wasIdle := idleUsers[userID]
+	lastActivity[userID] = time.Now()
+	delete(idleUsers, userID)
+	presenceMutex.Unlock()
+
+	if !wasIdle {
+		return
+	}
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		log.Printf("获取用户信息失败: %v", err)
+		return
+	}
+	if user.Presence == models.PresenceOnline {
+		broadcastPresence(utils.MessageTypePresenceUpdate, user)
+	}
+}
+
+// 清除用户的操作记录，用户的最后一个连接断开时调用
+func clearActivity(userID int64) {
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+	delete(lastActivity, userID)
+	delete(idleUsers, userID)
+}
+
+// 定期把超过空闲时间没有操作的用户标记为空闲，AwayAfter为0时不自动显示为离开
+func watchIdleUsers() {
+	if settings.AwayAfter <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		markIdleUsers(now)
+	}
+}
+
+// 标记空闲的用户，并广播其中设置为在线的用户变为离开
+func markIdleUsers(now time.Time) {
+	presenceMutex.Lock()
+	idle := make([]int64, 0)
+	for userID, last := range lastActivity {
+		if !idleUsers[userID] && now.Sub(last) >= settings.AwayAfter {
+			idleUsers[userID] = true
+			idle = append(idle, userID)
+		}
+	}
+	presenceMutex.Unlock()
+
+	for _, userID := range idle {
+		user, err := models.GetUserByID(userID)
+		if err != nil {
+			log.Printf("获取用户信息失败: %v", err)
+			continue
+		}
+		if user.Presence == models.PresenceOnline {
+			broadcastPresence(utils.MessageTypePresenceUpdate, user)
+		}
+	}
+}
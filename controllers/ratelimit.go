@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 限流的操作类型，同时作为丢弃计数的键
+const (
+	limitMessage = "message"
+	limitUpload  = "upload"
+	limitRename  = "rename"
+	limitRead    = "read"
+)
+
+const (
+	violationInterval  = time.Second // 同一用户在该间隔内的多次超限只警告一次、计一次违规
+	uploadLimiterPrune = 1000        // 上传限流器超过该数量时清理已补满的桶
+)
+
+// 每个连接的消息、昵称修改和已读回执限流器
+type clientLimiter struct {
+	message *utils.TokenBucket
+	rename  *utils.TokenBucket
+	read    *utils.TokenBucket
+}
+
+// 限流状态和统计，只保存在内存中
+var (
+	clientLimiters = make(map[*utils.Client]*clientLimiter)
+	uploadLimiters = make(map[int64]*utils.TokenBucket) // 文件通过HTTP上传，不属于某个连接，按用户限流
+	violations     = make(map[int64][]time.Time)        // 每个用户最近的违规时间
+	droppedCounts  = make(map[string]int64)
+	warningCount   int64
+	autoMuteCount  int64
+	limitMutex     = &sync.Mutex{}
+)
+
+// 检查连接是否可以执行操作，超出限制时丢弃并警告客户端
+func allowClient(client *utils.Client, kind string) bool {
+	limitMutex.Lock()
+	limiter, exists := clientLimiters[client]
+	if !exists {
+		limiter = &clientLimiter{
+			message: utils.NewTokenBucket(settings.MessageInterval, settings.MessageBurst),
+			rename:  utils.NewTokenBucket(settings.RenameInterval, settings.RenameBurst),
+			read:    utils.NewTokenBucket(settings.ReadInterval, settings.ReadBurst),
+		}
+		clientLimiters[client] = limiter
+	}
+	limitMutex.Unlock()
+
+	bucket := limiter.message
+	switch kind {
+	case limitRename:
+		bucket = limiter.rename
+	case limitRead:
+		bucket = limiter.read
+	}
+	ok, wait := bucket.Allow()
+	if ok {
+		return true
+	}
+
+	// 已读回执由客户端自动发送，超出限制时直接丢弃，不警告也不计入违规
+	if kind == limitRead {
+		limitMutex.Lock()
+		droppedCounts[kind]++
+		limitMutex.Unlock()
+		return false
+	}
+
+	warn, mute := recordViolation(client.ID, kind)
+	if warn {
+		client.Hub.SendToClient(client, &utils.Message{
+			Type:    utils.MessageTypeWarning,
+			Content: fmt.Sprintf("操作过于频繁，请%d秒后再试", retrySeconds(wait)),
+			Data: map[string]interface{}{
+				"action":      kind,
+				"retry_after": wait.Milliseconds(),
+			},
+		})
+	}
+	if mute {
+		autoMute(client.ID)
+	}
+	return false
+}
+
+// 检查用户是否可以上传文件，超出限制时写入429响应并返回false
+func allowUpload(c *gin.Context, userID int64) bool {
+	limitMutex.Lock()
+	bucket, exists := uploadLimiters[userID]
+	if !exists {
+		if len(uploadLimiters) >= uploadLimiterPrune {
+			for id, b := range uploadLimiters {
+				if b.Full() {
+					delete(uploadLimiters, id)
+				}
+			}
+		}
+		bucket = utils.NewTokenBucket(settings.UploadInterval, settings.UploadBurst)
+		uploadLimiters[userID] = bucket
+	}
+	limitMutex.Unlock()
+
+	ok, wait := bucket.Allow()
+	if ok {
+		return true
+	}
+
+	if _, mute := recordViolation(userID, limitUpload); mute {
+		autoMute(userID)
+	}
+	c.Header("Retry-After", fmt.Sprint(retrySeconds(wait)))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       fmt.Sprintf("上传过于频繁，请%d秒后再试", retrySeconds(wait)),
+		"retry_after": wait.Milliseconds(),
+	})
+	return false
+}
+
+// 等待时间向上取整到秒
+func retrySeconds(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// 记录一次被丢弃的操作，返回是否需要警告以及是否达到自动禁言的次数
+func recordViolation(userID int64, kind string) (warn bool, mute bool) {
+	limitMutex.Lock()
+	defer limitMutex.Unlock()
+
+	droppedCounts[kind]++
+
+	now := time.Now()
+	recent := violations[userID]
+	if len(recent) > 0 && now.Sub(recent[len(recent)-1]) < violationInterval {
+		return false, false
+	}
+	warningCount++
+	pruneViolations(now)
+
+	// 只保留统计时间范围内的违规
+	kept := make([]time.Time, 0, len(recent)+1)
+	for _, t := range recent {
+		if now.Sub(t) < settings.FloodWindow {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+
+	if settings.FloodViolations > 0 && len(kept) >= settings.FloodViolations {
+		delete(violations, userID)
+		return true, true
+	}
+	violations[userID] = kept
+	return true, false
+}
+
+// 删除最近一次违规已超出统计时间范围的用户，避免停止超限的用户一直占用内存，调用方需持有limitMutex
+func pruneViolations(now time.Time) {
+	window := settings.FloodWindow
+	if window < violationInterval {
+		window = violationInterval
+	}
+	for userID, recent := range violations {
+		if now.Sub(recent[len(recent)-1]) >= window {
+			delete(violations, userID)
+		}
+	}
+}
+
+// 自动禁言频繁超出限制的用户，并通知所有人
+func autoMute(userID int64) {
+	user, muted, err := models.AutoMute(userID, settings.FloodMute)
+	if err != nil {
+		log.Printf("自动禁言用户 %d 失败: %v", userID, err)
+		return
+	}
+	if !muted {
+		return
+	}
+
+	limitMutex.Lock()
+	autoMuteCount++
+	limitMutex.Unlock()
+
+	duration := models.FormatDuration(settings.FloodMute)
+	log.Printf("用户 %d 发送过于频繁，自动禁言%s", userID, duration)
+
+	broadcastSystemMessage(userID, fmt.Sprintf("%s 发送过于频繁，被自动禁言%s", userDisplayName(user), duration))
+	updatePresence(userID)
+}
+
+// 移除连接的限流器，连接断开时调用
+func clearRateLimits(client *utils.Client) {
+	limitMutex.Lock()
+	defer limitMutex.Unlock()
+	delete(clientLimiters, client)
+}
+
+// GetMetrics 获取限流的统计信息，包括各类被丢弃的操作数量、警告次数和自动禁言次数
+func GetMetrics(c *gin.Context) {
+	limitMutex.Lock()
+	dropped := map[string]int64{
+		limitMessage: droppedCounts[limitMessage],
+		limitUpload:  droppedCounts[limitUpload],
+		limitRename:  droppedCounts[limitRename],
+		limitRead:    droppedCounts[limitRead],
+	}
+	warnings, autoMutes := warningCount, autoMuteCount
+	limitMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"dropped":    dropped,
+		"warnings":   warnings,
+		"auto_mutes": autoMutes,
+	})
+}
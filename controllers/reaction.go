@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"log"
+
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 处理表情回应，同一用户对同一消息重复发送相同表情时取消回应
+func handleReaction(client *utils.Client, msg *utils.Message) error {
+	if msg.MessageID == 0 {
+		return errMissingMessageID
+	}
+
+	original, err := models.GetMessageByID(msg.MessageID)
+	if err != nil {
+		return err
+	}
+	if original.Status == models.MessageStatusRecalled || original.Type == models.MessageTypeSystem {
+		return errReactionNotAllowed
+	}
+
+	// 私信只允许会话双方回应，房间消息只允许房间成员回应
+	if original.RecipientID != 0 {
+		if client.ID != original.UserID && client.ID != original.RecipientID {
+			return errReactionForbidden
+		}
+	} else if !client.Hub.IsMember(client, original.RoomID) {
+		return errNotRoomMember
+	}
+
+	added, reactions, err := models.ToggleReaction(original.ID, client.ID, msg.Emoji)
+	if err != nil {
+		log.Printf("保存表情回应失败: %v", err)
+		return err
+	}
+
+	// 通知中附带该消息最新的汇总，客户端直接替换即可
+	notice := &utils.Message{
+		Type:      utils.MessageTypeReaction,
+		MessageID: original.ID,
+		UserID:    client.ID,
+		Username:  displayName(client),
+		Emoji:     msg.Emoji,
+		RoomID:    original.RoomID,
+		Data: map[string]interface{}{
+			"added":     added,
+			"reactions": reactions,
+		},
+	}
+
+	if original.RecipientID != 0 {
+		notice.RoomID = 0
+		notice.TargetID = original.RecipientID
+		client.Hub.SendToUsers([]int64{original.UserID, original.RecipientID}, notice)
+		return nil
+	}
+
+	client.Hub.BroadcastMessage(notice)
+	return nil
+}
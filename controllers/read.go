@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 处理已读回执，MessageID是客户端已经显示的最新消息
+//
+// 设置了TargetID时确认的是与该用户的私信会话，否则是所在的房间。
+// 已读位置前进时通知能看到这些消息的客户端，用于显示"已读"
+func handleRead(client *utils.Client, msg *utils.Message) error {
+	if msg.MessageID == 0 {
+		return errMissingMessageID
+	}
+
+	notice := &utils.Message{
+		Type:      utils.MessageTypeRead,
+		MessageID: msg.MessageID,
+		UserID:    client.ID,
+	}
+
+	if msg.TargetID != 0 {
+		advanced, err := models.MarkConversationRead(client.ID, msg.TargetID, msg.MessageID)
+		if err != nil || !advanced {
+			return err
+		}
+		notice.Username = displayName(client)
+		notice.TargetID = msg.TargetID
+		client.Hub.SendToUsers([]int64{client.ID, msg.TargetID}, notice)
+		return nil
+	}
+
+	if err := checkRoomMember(client, msg); err != nil {
+		return err
+	}
+	advanced, err := models.MarkRoomRead(client.ID, msg.RoomID, msg.MessageID)
+	if err != nil || !advanced {
+		return err
+	}
+	notice.Username = displayName(client)
+	notice.RoomID = msg.RoomID
+	client.Hub.BroadcastMessage(notice)
+	return nil
+}
+
+// 带显示名称的已读位置
+type readStateView struct {
+	*models.ReadState
+	Username string `json:"username"`
+}
+
+// GetUnreadCounts 获取当前用户在各房间和各私信会话中的未读消息数量
+func GetUnreadCounts(c *gin.Context) {
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	counts, err := models.GetUnreadCounts(user.ID)
+	if err != nil {
+		log.Printf("统计未读消息失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计未读消息失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// GetReadStates 获取房间（room参数）或与指定用户私信会话（peer参数）中各用户的已读位置
+func GetReadStates(c *gin.Context) {
+	roomID, ok := parsePositiveInt(c, "room")
+	if !ok {
+		return
+	}
+	peerID, ok := parsePositiveInt(c, "peer")
+	if !ok {
+		return
+	}
+	if (roomID == 0) == (peerID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要指定room或peer参数之一"})
+		return
+	}
+
+	var states []*models.ReadState
+	if peerID != 0 {
+		// 当前用户总是会话的一方，因此只有双方能读取会话的已读位置
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var err error
+		states, err = models.GetConversationReadStates(user.ID, peerID)
+		if err != nil {
+			log.Printf("获取已读位置失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取已读位置失败"})
+			return
+		}
+	} else {
+		if _, err := models.GetRoomByID(roomID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "房间不存在"})
+			return
+		}
+		var err error
+		states, err = models.GetRoomReadStates(roomID)
+		if err != nil {
+			log.Printf("获取已读位置失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取已读位置失败"})
+			return
+		}
+	}
+
+	views := make([]readStateView, 0, len(states))
+	for _, state := range states {
+		view := readStateView{ReadState: state}
+		if user, err := models.GetUserByID(state.UserID); err == nil {
+			view.Username = userDisplayName(user)
+		}
+		views = append(views, view)
+	}
+
+	c.JSON(http.StatusOK, views)
+}
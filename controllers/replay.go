@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 保存的消息类型对应的WebSocket消息类型
+var liveMessageTypes = map[int]string{
+	models.MessageTypeText:   utils.MessageTypeText,
+	models.MessageTypeImage:  utils.MessageTypeImage,
+	models.MessageTypeEmoji:  utils.MessageTypeEmoji,
+	models.MessageTypeSystem: utils.MessageTypeSystem,
+	models.MessageTypeFile:   utils.MessageTypeFile,
+}
+
+// 把保存的消息转换为实时推送时的格式
+func liveMessage(msg *models.Message) *utils.Message {
+	if msg.Type == models.MessageTypeSystem {
+		return &utils.Message{
+			Type:      utils.MessageTypeSystem,
+			Content:   msg.Content,
+			MessageID: msg.ID,
+			RoomID:    msg.RoomID,
+		}
+	}
+
+	live := &utils.Message{
+		Type:      liveMessageTypes[msg.Type],
+		Content:   msg.Content,
+		Username:  msg.UsernameStr,
+		UserID:    msg.UserID,
+		MessageID: msg.ID,
+		FileName:  msg.FileNameStr,
+		FileSize:  msg.FileSizeVal,
+		RoomID:    msg.RoomID,
+		FileID:    msg.FileID,
+		ReplyTo:   msg.ReplyTo,
+	}
+	if msg.RecipientID != 0 {
+		live.Type = utils.MessageTypeDirect
+		live.TargetID = msg.RecipientID
+	}
+	return live
+}
+
+// 消息的编辑通知，与实时编辑通知的格式一致
+func editNotice(msg *models.Message) *utils.Message {
+	return &utils.Message{
+		Type:      utils.MessageTypeEdit,
+		MessageID: msg.ID,
+		Content:   msg.Content,
+		UserID:    msg.UserID,
+		Username:  msg.UsernameStr,
+		RoomID:    msg.RoomID,
+		TargetID:  msg.RecipientID,
+		Data: map[string]interface{}{
+			"edited_at": msg.EditedAt,
+		},
+	}
+}
+
+// 消息的撤回通知，与实时撤回通知的格式一致
+func recallNotice(msg *models.Message) *utils.Message {
+	return &utils.Message{
+		Type:      utils.MessageTypeRecall,
+		MessageID: msg.ID,
+		UserID:    msg.UserID,
+		Username:  msg.UsernameStr,
+		RoomID:    msg.RoomID,
+		TargetID:  msg.RecipientID,
+	}
+}
+
+// 获取客户端断线期间错过的roomIDs中房间的消息和私信，since是客户端收到的最后一条消息的ID
+//
+// 先补发since及之前被编辑或撤回的消息，再按顺序补发新消息；错过的消息过多时只返回resync通知。
+// 返回的消息由写入协程在处理发送队列之前写出。连接已经加入广播，补发期间的新消息在发送队列中
+// 等待，会在补发之后送达，可能与补发的消息重复，客户端按消息ID去重
+func replayMissed(client *utils.Client, roomIDs []int64, since int64) [][]byte {
+	messages, changed, err := models.GetMissedMessages(client.ID, roomIDs, since, settings.ReplayLimit)
+	if err != nil {
+		if !errors.Is(err, models.ErrReplayGap) {
+			log.Printf("获取用户 %d 错过的消息失败: %v", client.ID, err)
+		}
+		return encodeMessages([]*utils.Message{{
+			Type:    utils.MessageTypeResync,
+			Content: models.ErrReplayGap.Error(),
+			Data: map[string]interface{}{
+				"since": since,
+			},
+		}})
+	}
+
+	frames := make([]*utils.Message, 0, len(changed)+len(messages))
+	for _, msg := range changed {
+		if msg.Status == models.MessageStatusRecalled {
+			frames = append(frames, recallNotice(msg))
+		} else {
+			frames = append(frames, editNotice(msg))
+		}
+	}
+	for _, msg := range messages {
+		// 断线期间发送又撤回的消息不再补发
+		if msg.Status == models.MessageStatusRecalled {
+			continue
+		}
+		frames = append(frames, liveMessage(msg))
+		if msg.EditedAt != nil {
+			frames = append(frames, editNotice(msg))
+		}
+	}
+
+	return encodeMessages(frames)
+}
+
+// 序列化多条消息，序列化失败的消息被跳过
+func encodeMessages(messages []*utils.Message) [][]byte {
+	encoded := make([][]byte, 0, len(messages))
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("错误: 消息序列化失败: %v", err)
+			continue
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+// 房间名称最大长度
+const maxRoomNameLength = 20
+
+// 检查消息的目标房间，未指定时使用默认房间
+func checkRoomMember(client *utils.Client, msg *utils.Message) error {
+	if msg.RoomID == 0 {
+		msg.RoomID = models.DefaultRoomID
+	}
+
+	if !client.Hub.IsMember(client, msg.RoomID) {
+		return errNotRoomMember
+	}
+	return nil
+}
+
+// 解析逗号分隔的房间ID
+func parseRoomIDs(value string) ([]int64, bool) {
+	roomIDs := make([]int64, 0)
+	for _, field := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		roomIDs = append(roomIDs, id)
+	}
+	return roomIDs, true
+}
+
+// 过滤掉不存在和重复的房间，没有可加入的房间时使用默认房间
+func existingRooms(roomIDs []int64) []int64 {
+	rooms, err := models.GetRooms()
+	if err != nil {
+		log.Printf("获取房间列表失败: %v", err)
+		return []int64{models.DefaultRoomID}
+	}
+	exists := make(map[int64]bool, len(rooms))
+	for _, room := range rooms {
+		exists[room.ID] = true
+	}
+
+	existing := make([]int64, 0, len(roomIDs))
+	for _, id := range roomIDs {
+		if exists[id] {
+			existing = append(existing, id)
+			delete(exists, id)
+		}
+	}
+	if len(existing) == 0 {
+		existing = append(existing, models.DefaultRoomID)
+	}
+	return existing
+}
+
+// 处理加入房间
+func handleRoomJoin(client *utils.Client, msg *utils.Message) error {
+	room, err := models.GetRoomByID(msg.RoomID)
+	if err != nil {
+		return err
+	}
+
+	if client.Hub.IsMember(client, room.ID) {
+		return nil
+	}
+	client.Hub.JoinRoom(client, room.ID)
+
+	// 通知客户端已加入房间
+	client.Hub.SendToClient(client, &utils.Message{
+		Type:   utils.MessageTypeRoomJoin,
+		RoomID: room.ID,
+		Data:   room,
+	})
+
+	// 通知房间内其他成员
+	broadcastRoomSystemMessage(client.ID, room.ID, fmt.Sprintf("%s 加入了房间 %s", displayName(client), room.Name))
+
+	return nil
+}
+
+// 处理离开房间
+func handleRoomLeave(client *utils.Client, msg *utils.Message) error {
+	if !client.Hub.IsMember(client, msg.RoomID) {
+		return nil
+	}
+
+	room, err := models.GetRoomByID(msg.RoomID)
+	if err != nil {
+		return err
+	}
+	client.Hub.LeaveRoom(client, room.ID)
+	stopTyping(client, room.ID, 0)
+
+	// 通知客户端已离开房间
+	client.Hub.SendToClient(client, &utils.Message{
+		Type:   utils.MessageTypeRoomLeave,
+		RoomID: room.ID,
+	})
+
+	// 通知房间内剩余成员
+	broadcastRoomSystemMessage(client.ID, room.ID, fmt.Sprintf("%s 离开了房间 %s", displayName(client), room.Name))
+
+	return nil
+}
+
+// 获取客户端的显示名称
+func displayName(client *utils.Client) string {
+	user, err := models.GetUserByID(client.ID)
+	if err != nil {
+		return client.IP
+	}
+	return userDisplayName(user)
+}
+
+// 获取用户的显示名称，依次使用昵称、登录名和IP
+func userDisplayName(user *models.User) string {
+	if user.UsernameStr != "" {
+		return user.UsernameStr
+	}
+	if user.LoginNameStr != "" {
+		return user.LoginNameStr
+	}
+	return user.IP
+}
+
+// GetRooms 获取房间列表
+func GetRooms(c *gin.Context) {
+	rooms, err := models.GetRooms()
+	if err != nil {
+		log.Printf("获取房间列表失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取房间列表失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rooms)
+}
+
+// CreateRoom 创建新房间
+func CreateRoom(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "房间名称不能为空"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	if utf8.RuneCountInString(req.Name) > maxRoomNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("房间名称不能超过%d个字符", maxRoomNameLength)})
+		return
+	}
+
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	room, err := models.CreateRoom(req.Name, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrRoomExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("创建房间失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建房间失败"})
+		return
+	}
+
+	// 广播最新的房间列表
+	broadcastRooms()
+
+	c.JSON(http.StatusOK, room)
+}
+
+// 向所有客户端广播房间列表
+func broadcastRooms() {
+	rooms, err := models.GetRooms()
+	if err != nil {
+		log.Printf("获取房间列表失败: %v", err)
+		return
+	}
+
+	Hub.BroadcastMessage(&utils.Message{
+		Type: utils.MessageTypeRooms,
+		Data: rooms,
+	})
+}
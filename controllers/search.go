@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+)
+
+// 搜索时可以筛选的消息类型
+var searchTypes = map[string]int{
+	"text":  models.MessageTypeText,
+	"image": models.MessageTypeImage,
+	"emoji": models.MessageTypeEmoji,
+	"file":  models.MessageTypeFile,
+}
+
+// 日期参数可以只写日期，也可以是带时区的完整时间
+const searchDateLayout = "2006-01-02"
+
+// 解析日期参数，只写日期时按服务器所在时区的当天零点计算，endOfDay为true时取第二天零点
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(searchDateLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// 解析正整数参数，参数为空时返回0
+func parsePositiveInt(c *gin.Context, name string) (int64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + "参数无效"})
+		return 0, false
+	}
+	return n, true
+}
+
+// SearchMessages 搜索聊天历史消息
+//
+// q为关键字，多个关键字用空格分隔且需要全部匹配；可以用sender（用户ID）、
+// type（text、image、emoji、file，多个用逗号分隔）、room（房间ID）、
+// from和to（日期或RFC3339时间，to当天包含在内）筛选，用offset和limit分页。
+// 响应中的next_offset用于获取下一页，没有更多结果时为null
+func SearchMessages(c *gin.Context) {
+	query := models.SearchQuery{Terms: models.ParseSearchTerms(c.Query("q"))}
+	if len(query.Terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "搜索关键字不能为空"})
+		return
+	}
+
+	var ok bool
+	if query.SenderID, ok = parsePositiveInt(c, "sender"); !ok {
+		return
+	}
+	if query.RoomID, ok = parsePositiveInt(c, "room"); !ok {
+		return
+	}
+
+	if types := c.Query("type"); types != "" {
+		for _, name := range strings.Split(types, ",") {
+			t, exists := searchTypes[strings.TrimSpace(name)]
+			if !exists {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "消息类型无效: " + name})
+				return
+			}
+			query.Types = append(query.Types, t)
+		}
+	}
+
+	for name, target := range map[string]*time.Time{"from": &query.Since, "to": &query.Until} {
+		if value := c.Query(name); value != "" {
+			t, err := parseSearchTime(value, name == "to")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + "参数无效"})
+				return
+			}
+			*target = t
+		}
+	}
+
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset参数无效"})
+			return
+		}
+		query.Offset = offset
+	}
+	limit, ok := parsePositiveInt(c, "limit")
+	if !ok {
+		return
+	}
+	query.Limit = int(limit)
+
+	results, next, err := models.SearchMessages(query)
+	if errors.Is(err, models.ErrEmptySearch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("搜索消息失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索消息失败"})
+		return
+	}
+
+	var nextOffset interface{}
+	if next != 0 {
+		nextOffset = next
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results":     results,
+		"next_offset": nextOffset,
+	})
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+)
+
+// 聊天室名称最大长度
+const maxTitleLength = 30
+
+// 聊天室名称，初始值来自配置，可在运行时修改
+var (
+	chatTitle  string
+	titleMutex = &sync.RWMutex{}
+)
+
+// 获取当前聊天室名称
+func getChatTitle() string {
+	titleMutex.RLock()
+	defer titleMutex.RUnlock()
+	return chatTitle
+}
+
+// Index 聊天室首页
+func Index(c *gin.Context) {
+	title := getChatTitle()
+	c.HTML(http.StatusOK, "index.html", gin.H{
+		"title":     title,
+		"chatTitle": title,
+	})
+}
+
+// UpdateTitle 更新聊天室标题，只有管理员可以修改
+func UpdateTitle(c *gin.Context) {
+	var req struct {
+		Title string `json:"title" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Title) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "标题不能为空"})
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+
+	if utf8.RuneCountInString(req.Title) > maxTitleLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("标题不能超过%d个字符", maxTitleLength)})
+		return
+	}
+
+	// 更新标题
+	titleMutex.Lock()
+	chatTitle = req.Title
+	titleMutex.Unlock()
+	admin := currentAdmin(c)
+	models.RecordTitleChange(admin.ID, req.Title)
+
+	// 广播标题更新消息
+	broadcastSystemMessage(admin.ID, "聊天室名称已更新为："+req.Title)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"title":   req.Title,
+	})
+}
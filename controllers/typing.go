@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mikewang/go-gin-websocket-msg/utils"
+)
+
+const (
+	typingTimeout     = 5 * time.Second // 超过该时间没有刷新时自动结束输入状态
+	typingMinInterval = time.Second     // 同一连接发送输入状态的最小间隔，更频繁的消息直接丢弃
+)
+
+// 输入状态所在的范围，每个连接在每个房间或私信会话中各有一个状态
+type typingKey struct {
+	client   *utils.Client
+	roomID   int64 // 房间ID，私信为0
+	targetID int64 // 私信对方的用户ID，房间为0
+}
+
+// 正在输入的状态及其过期计时器，只保存在内存中
+var (
+	typingStates = make(map[typingKey]*time.Timer)
+	typingLast   = make(map[*utils.Client]time.Time)
+	typingMutex  = &sync.Mutex{}
+)
+
+// 处理正在输入的状态，data为{"typing": false}时表示停止输入
+//
+// 输入状态不保存，只转发给房间内的其他客户端或私信的对方。
+// 客户端需要在typingTimeout内重复发送以保持状态，否则服务端自动通知停止输入
+func handleTyping(client *utils.Client, msg *utils.Message) error {
+	key := typingKey{client: client}
+	if msg.TargetID != 0 {
+		if msg.TargetID == client.ID {
+			return errInvalidRecipient
+		}
+		key.targetID = msg.TargetID
+	} else {
+		if err := checkRoomMember(client, msg); err != nil {
+			return err
+		}
+		key.roomID = msg.RoomID
+	}
+
+	if typingStopped(msg) {
+		stopTyping(client, key.roomID, key.targetID)
+		return nil
+	}
+
+	typingMutex.Lock()
+	now := time.Now()
+	if now.Sub(typingLast[client]) < typingMinInterval {
+		typingMutex.Unlock()
+		return nil
+	}
+	typingLast[client] = now
+
+	old, typing := typingStates[key]
+	if typing {
+		old.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(typingTimeout, func() {
+		expireTyping(key, timer)
+	})
+	typingStates[key] = timer
+	typingMutex.Unlock()
+
+	// 已经在输入时只刷新过期时间，不重复通知
+	if !typing {
+		relayTyping(key, true)
+	}
+	return nil
+}
+
+// 判断消息是否表示停止输入
+func typingStopped(msg *utils.Message) bool {
+	data, ok := msg.Data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	typing, ok := data["typing"].(bool)
+	return ok && !typing
+}
+
+// 结束客户端在指定房间或私信会话中的输入状态，发送消息或离开房间时调用
+func stopTyping(client *utils.Client, roomID, targetID int64) {
+	key := typingKey{client: client, roomID: roomID, targetID: targetID}
+
+	typingMutex.Lock()
+	timer, typing := typingStates[key]
+	if typing {
+		timer.Stop()
+		delete(typingStates, key)
+	}
+	typingMutex.Unlock()
+
+	if typing {
+		relayTyping(key, false)
+	}
+}
+
+// 结束客户端所有的输入状态，连接断开时调用
+func clearTyping(client *utils.Client) {
+	typingMutex.Lock()
+	keys := make([]typingKey, 0)
+	for key, timer := range typingStates {
+		if key.client == client {
+			timer.Stop()
+			delete(typingStates, key)
+			keys = append(keys, key)
+		}
+	}
+	delete(typingLast, client)
+	typingMutex.Unlock()
+
+	for _, key := range keys {
+		relayTyping(key, false)
+	}
+}
+
+// 输入状态过期，计时器已被替换或停止时忽略
+func expireTyping(key typingKey, timer *time.Timer) {
+	typingMutex.Lock()
+	if typingStates[key] != timer {
+		typingMutex.Unlock()
+		return
+	}
+	delete(typingStates, key)
+	typingMutex.Unlock()
+
+	relayTyping(key, false)
+}
+
+// 通知其他客户端输入状态的变化
+func relayTyping(key typingKey, typing bool) {
+	client := key.client
+	notice := &utils.Message{
+		Type:     utils.MessageTypeTyping,
+		UserID:   client.ID,
+		Username: displayName(client),
+		Data: map[string]interface{}{
+			"typing": typing,
+		},
+	}
+
+	if key.targetID != 0 {
+		notice.TargetID = key.targetID
+		client.Hub.SendToUsers([]int64{key.targetID}, notice)
+		return
+	}
+
+	notice.RoomID = key.roomID
+	client.Hub.BroadcastExcept(notice, client)
+}
@@ -1,22 +1,25 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"time"
 	
 	"github.com/gin-gonic/gin"
+	"github.com/mikewang/go-gin-websocket-msg/config"
 	"github.com/mikewang/go-gin-websocket-msg/controllers"
 	"github.com/mikewang/go-gin-websocket-msg/models"
-	"github.com/mikewang/go-gin-websocket-msg/utils"
 )
 
 // 应用版本和构建时间，将在编译时通过ldflags注入
 var (
 	Version   = "dev"
 	BuildTime = "unknown"
-	ChatTitle = "局域网聊天室" // 聊天室名称配置
 )
 
 // 获取本机IP地址
@@ -41,8 +44,30 @@ func main() {
 	// 输出应用版本信息
 	fmt.Printf("聊天室应用 版本: %s (构建时间: %s)\n", Version, BuildTime)
 	
+	// 数据库迁移子命令
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal("数据库迁移失败: ", err)
+		}
+		return
+	}
+	
+	// 加载配置
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal("加载配置失败: ", err)
+	}
+	
 	// 初始化数据库
-	models.InitDB()
+	if err := models.InitDB(cfg); err != nil {
+		log.Fatal("初始化数据库失败: ", err)
+	}
+	
+	// 初始化Hub和文件存储
+	controllers.Init(cfg)
 	
 	// 获取本机IP，显示访问地址
 	localIP, err := getLocalIP()
@@ -50,74 +75,82 @@ func main() {
 		log.Printf("获取本机IP失败: %v", err)
 		localIP = "127.0.0.1"
 	}
-	fmt.Printf("请通过浏览器访问: http://%s:8081\n", localIP)
+	_, port, _ := net.SplitHostPort(cfg.Addr)
+	fmt.Printf("请通过浏览器访问: http://%s\n", net.JoinHostPort(localIP, port))
 	
 	// 设置路由
 	r := gin.Default()
 	
+	// 只采信可信代理转发的X-Forwarded-For，否则客户端可以伪造IP绕过封禁
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("设置可信代理失败: ", err)
+	}
+	
 	// 静态文件
-	r.Static("/static", "./static")
+	r.Static("/static", cfg.StaticDir)
 	
 	// HTML 模板
-	r.LoadHTMLGlob("templates/*")
+	r.LoadHTMLGlob(filepath.Join(cfg.TemplateDir, "*"))
 	
 	// 聊天室首页
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(200, "index.html", gin.H{
-			"title":     ChatTitle,
-			"chatTitle": ChatTitle,
-		})
-	})
+	r.GET("/", controllers.Index)
 	
 	// WebSocket 路由
 	r.GET("/ws", controllers.HandleWebSocket)
 	
-	// API 路由
-	r.GET("/api/messages", controllers.GetMessages)
-	r.GET("/api/messages/search", controllers.SearchMessages)
-	r.GET("/api/users/online", controllers.GetOnlineUsers)
-	r.GET("/api/statistics", controllers.GetStatistics)
+	// API 路由，被封禁的用户和IP不能访问
+	api := r.Group("/api", controllers.RejectBanned)
 	
-	// 更新聊天室标题
-	r.POST("/api/title", func(c *gin.Context) {
-		var req struct {
-			Title string `json:"title" binding:"required"`
-		}
-		
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": "标题不能为空"})
-			return
-		}
-		
-		// 更新标题
-		ChatTitle = req.Title
-		
-		// 广播标题更新消息
-		systemMsg := &utils.Message{
-			Type:    utils.MessageTypeSystem,
-			Content: "聊天室名称已更新为：" + ChatTitle,
-		}
-		controllers.Hub.BroadcastMessage(systemMsg)
-		
-		c.JSON(200, gin.H{
-			"success": true,
-			"title":   ChatTitle,
-		})
-	})
+	// 用户身份，访客只在进入聊天室时创建，其他接口没有会话时返回401
+	api.POST("/enter", controllers.Enter)
+	api.GET("/me", controllers.GetCurrentUser)
+	api.POST("/register", controllers.Register)
+	api.POST("/login", controllers.Login)
+	api.POST("/logout", controllers.Logout)
+	
+	// 消息、房间和文件
+	api.GET("/messages", controllers.GetMessages)
+	api.GET("/messages/search", controllers.SearchMessages)
+	api.GET("/messages/:id/thread", controllers.GetThread)
+	api.GET("/messages/:id/edits", controllers.GetMessageEdits)
+	api.GET("/users/online", controllers.GetOnlineUsers)
+	api.GET("/statistics", controllers.GetStatistics)
+	api.GET("/rooms", controllers.GetRooms)
+	api.POST("/rooms", controllers.CreateRoom)
+	api.GET("/conversations/:userID", controllers.GetConversation)
+	api.GET("/unread", controllers.GetUnreadCounts)
+	api.GET("/mentions", controllers.GetMentions)
+	api.GET("/reads", controllers.GetReadStates)
+	api.POST("/files", controllers.UploadFile)
+	api.GET("/files/:id", controllers.DownloadFile)
+	
+	// 管理员操作
+	api.POST("/title", controllers.RequireAdmin, controllers.UpdateTitle)
+	admin := api.Group("/admin", controllers.RequireAdmin)
+	admin.DELETE("/messages/:id", controllers.DeleteMessage)
+	admin.POST("/users/:id/kick", controllers.KickUser)
+	admin.POST("/users/:id/ban", controllers.BanUser)
+	admin.POST("/users/:id/mute", controllers.MuteUser)
+	admin.DELETE("/users/:id/mute", controllers.UnmuteUser)
+	admin.GET("/bans", controllers.GetBans)
+	admin.POST("/bans", controllers.CreateBan)
+	admin.DELETE("/bans/:id", controllers.RemoveBan)
+	admin.GET("/audit", controllers.GetAuditLog)
+	admin.GET("/metrics", controllers.GetMetrics)
 	
 	// 启动定时清理任务
-	go cleanupInactiveUsers()
+	go cleanupInactiveUsers(cfg.CleanupInterval)
 	
 	// 启动服务器
-	fmt.Println("启动服务器，监听端口 8081...")
-	if err := r.Run(":8081"); err != nil {
+	fmt.Printf("启动服务器，监听地址 %s...\n", cfg.Addr)
+	if err := r.Run(cfg.Addr); err != nil {
 		log.Fatal("启动服务器失败:", err)
 	}
 }
 
 // 定时清理不活跃用户
-func cleanupInactiveUsers() {
-	ticker := time.NewTicker(5 * time.Minute)
+func cleanupInactiveUsers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	
 	for range ticker.C {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mikewang/go-gin-websocket-msg/config"
+	"github.com/mikewang/go-gin-websocket-msg/models"
+)
+
+// 数据库迁移子命令的用法
+const migrateUsage = `用法: chat-app migrate <status|up> [参数]
+
+  status  查看SQLite数据库的迁移状态
+  up      执行尚未执行的迁移
+
+参数与启动服务器时相同，例如 -db 指定数据库文件，只校验数据库相关的参数`
+
+// runMigrate 执行 migrate 子命令
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		return errors.New("缺少子命令")
+	}
+
+	cfg, err := config.LoadDatabase(args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		return migrateStatus(cfg.DBFile)
+	case "up":
+		done, err := models.MigrateUp(cfg.DBFile)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("数据库已是最新版本")
+			return nil
+		}
+		for _, m := range done {
+			fmt.Printf("已执行 %04d_%s\n", m.Version, m.Name)
+		}
+		return nil
+	default:
+		fmt.Println(migrateUsage)
+		return fmt.Errorf("未知的子命令: %s", args[0])
+	}
+}
+
+// 打印迁移状态
+func migrateStatus(path string) error {
+	states, err := models.MigrationStatus(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("数据库: %s\n\n", path)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "版本\t名称\t状态\t执行时间")
+	pending := 0
+	for _, state := range states {
+		status, appliedAt := "未执行", "-"
+		if state.Applied {
+			status, appliedAt = "已执行", state.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		} else {
+			pending++
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", state.Version, state.Name, status, appliedAt)
+	}
+	w.Flush()
+
+	fmt.Printf("\n共 %d 个迁移，%d 个未执行\n", len(states), pending)
+	return nil
+}
@@ -1,131 +1,87 @@
 package models
 
 import (
-	"database/sql"
 	"errors"
+	"fmt"
 	"log"
-	"sync"
-	
-	_ "github.com/mattn/go-sqlite3"
+	"time"
+
+	"github.com/mikewang/go-gin-websocket-msg/config"
 )
 
-// DB 是全局数据库连接
-var DB *sql.DB
+// 当前使用的数据存储，由InitDB根据配置选择
+var store Store = NewMemoryStore()
 
-// 内存数据库模式使用的变量
-var (
-	UsersMap     = make(map[int64]*User)
-	MessagesMap  = make(map[int64]*Message)
-	usersMutex   = &sync.RWMutex{}
-	messageMutex = &sync.RWMutex{}
-	LastUserID   int64 = 0
-	LastMsgID    int64 = 0
-	UseMemoryMode      = false
-)
+// 数据层使用的配置，由InitDB传入
+var settings = config.Default()
 
 // 数据库错误
 var (
-	ErrNotImplemented = errors.New("功能在内存模式下未实现")
-	ErrNoRows         = errors.New("未找到记录")
+	ErrNoRows             = errors.New("未找到记录")
+	ErrRoomExists         = errors.New("房间名称已存在")
+	ErrRoomNotFound       = errors.New("房间不存在")
+	ErrFileNotFound       = errors.New("文件不存在")
+	ErrMessageNotFound    = errors.New("消息不存在")
+	ErrBanNotFound        = errors.New("封禁记录不存在")
+	ErrSessionNotFound    = errors.New("会话不存在或已过期")
+	ErrLoginNameTaken     = errors.New("登录名已被使用")
+	ErrAlreadyRegistered  = errors.New("当前用户已注册")
+	ErrInvalidCredentials = errors.New("登录名或密码错误")
 )
 
-// InitDB 初始化数据库连接
-func InitDB() {
-	// 检查CGO是否启用，尝试使用SQLite
-	UseMemoryMode = false
-	var err error
-	
-	// 尝试连接SQLite文件数据库
-	DB, err = sql.Open("sqlite3", "chat.db")
-	
-	if err != nil || checkDBConnection() != nil {
-		log.Printf("SQLite数据库不可用: %v", err)
-		log.Println("切换到内存数据模式...")
-		UseMemoryMode = true
-		
-		// 初始化内存数据
-		initMemoryData()
-		return
+// InitDB 根据配置选择并初始化数据存储
+//
+// auto模式下优先使用SQLite文件数据库，无法打开时（例如未启用CGO）切换到内存模式；
+// 迁移失败或数据库版本高于程序支持的版本时返回错误，不会切换到内存模式
+func InitDB(cfg *config.Config) error {
+	settings = cfg
+	if err := selectStore(cfg); err != nil {
+		return err
 	}
-	
-	// 创建表
-	createTables()
-	
-	// 更新表结构
-	updateTables()
-	
-	log.Println("数据库初始化完成，使用SQLite文件数据库")
-}
 
-// 检查数据库连接是否正常
-func checkDBConnection() error {
-	return DB.Ping()
+	bootstrapAdmins(cfg.Admins)
+	return nil
 }
 
-// createTables 创建数据库表
-func createTables() {
-	// 创建用户表
-	_, err := DB.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			ip TEXT NOT NULL,
-			username TEXT,
-			last_online TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	if err != nil {
-		log.Printf("创建用户表失败: %v", err)
-	}
-	
-	// 创建消息表
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS messages (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER,
-			content TEXT NOT NULL,
-			type INTEGER DEFAULT 0,
-			status INTEGER DEFAULT 0,
-			file_name TEXT,
-			file_size INTEGER,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users (id)
-		)
-	`)
-	if err != nil {
-		log.Printf("创建消息表失败: %v", err)
+// 根据配置选择数据存储
+func selectStore(cfg *config.Config) error {
+	switch cfg.Storage {
+	case config.StorageMemory:
+		store = NewMemoryStore()
+		log.Println("数据库初始化完成，使用内存数据模式")
+		return nil
+	case config.StorageSQLite:
+		s, err := NewSQLiteStore(cfg.DBFile)
+		if err != nil {
+			return err
+		}
+		store = s
+	default:
+		s, err := NewSQLiteStore(cfg.DBFile)
+		if errors.Is(err, ErrSQLiteUnavailable) {
+			log.Printf("%v", err)
+			log.Println("切换到内存数据模式...")
+			store = NewMemoryStore()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		store = s
 	}
+
+	log.Println("数据库初始化完成，使用SQLite文件数据库")
+	return nil
 }
 
-// updateTables 更新表结构，添加新列
-func updateTables() {
-	// 添加消息状态列
-	_, err := DB.Exec("ALTER TABLE messages ADD COLUMN status INTEGER DEFAULT 0")
-	if err != nil {
-		// 忽略错误，列可能已存在
-		log.Printf("添加status列: %v", err)
-	}
-	
-	// 添加文件名列
-	_, err = DB.Exec("ALTER TABLE messages ADD COLUMN file_name TEXT")
-	if err != nil {
-		// 忽略错误，列可能已存在
-		log.Printf("添加file_name列: %v", err)
-	}
-	
-	// 添加文件大小列
-	_, err = DB.Exec("ALTER TABLE messages ADD COLUMN file_size INTEGER")
-	if err != nil {
-		// 忽略错误，列可能已存在
-		log.Printf("添加file_size列: %v", err)
+// FormatDuration 把时长格式化为中文描述，例如"8小时"、"30分钟"
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d小时", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%d分钟", d/time.Minute)
+	default:
+		return d.String()
 	}
 }
-
-// 初始化内存数据
-func initMemoryData() {
-	log.Println("初始化内存数据模式...")
-	// 清空现有数据
-	UsersMap = make(map[int64]*User)
-	MessagesMap = make(map[int64]*Message)
-	LastUserID = 0
-	LastMsgID = 0
-} 
\ No newline at end of file
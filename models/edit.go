@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 编辑相关错误
+var (
+	ErrEditForbidden = errors.New("只能编辑自己发送的文本消息")
+	ErrEditRecalled  = errors.New("不能编辑已撤回的消息")
+	ErrEditEmpty     = errors.New("消息内容不能为空")
+	ErrEditUnchanged = errors.New("消息内容没有变化")
+	ErrEditExpired   = errors.New("消息已超过可编辑时间")
+	ErrEditsRecalled = errors.New("消息已撤回，不能查看编辑历史")
+)
+
+// MessageEdit 是消息被编辑前的一个版本
+type MessageEdit struct {
+	ID        int64     `json:"id"`
+	MessageID int64     `json:"message_id"`
+	Content   string    `json:"content"`   // 编辑前的内容
+	EditedAt  time.Time `json:"edited_at"` // 被替换的时间
+}
+
+// EditMessage 修改消息内容，只有发送者可以在编辑时限内修改文本消息
+func EditMessage(messageID, userID int64, content string) (*Message, error) {
+	msg, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.UserID != userID || msg.Type != MessageTypeText {
+		return nil, ErrEditForbidden
+	}
+	if msg.Status == MessageStatusRecalled {
+		return nil, ErrEditRecalled
+	}
+	if err := checkNotMuted(userID); err != nil {
+		return nil, err
+	}
+	if time.Since(msg.CreatedAt) > settings.EditWindow {
+		return nil, fmt.Errorf("%w，只能编辑%s内的消息", ErrEditExpired, FormatDuration(settings.EditWindow))
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return nil, ErrEditEmpty
+	}
+	if err := checkContentLength(content); err != nil {
+		return nil, err
+	}
+	if content == msg.Content {
+		return nil, ErrEditUnchanged
+	}
+
+	if err := store.EditMessage(messageID, content); err != nil {
+		return nil, err
+	}
+	return store.GetMessageByID(messageID)
+}
+
+// GetMessageEdits 获取消息的历史版本，已撤回或被删除的消息不再提供之前的版本
+func GetMessageEdits(messageID int64) ([]*MessageEdit, error) {
+	msg, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status == MessageStatusRecalled {
+		return nil, ErrEditsRecalled
+	}
+	return store.GetMessageEdits(messageID)
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrFileForbidden 表示引用了其他用户上传的文件
+var ErrFileForbidden = errors.New("只能发送自己上传的文件")
+
+// 允许作为图片消息发送的文件类型，上传时会校验内容能按该类型解码
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// IsImageType 判断文件类型是否允许作为图片消息发送
+func IsImageType(mimeType string) bool {
+	return imageTypes[mimeType]
+}
+
+// File 表示上传的文件，内容按哈希存放在磁盘上
+type File struct {
+	ID         int64     `json:"id"`
+	Hash       string    `json:"-"`
+	Name       string    `json:"name"`
+	MimeType   string    `json:"mime_type"`
+	Size       int64     `json:"size"`
+	UploaderID int64     `json:"uploader_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateFile 记录上传的文件信息
+func CreateFile(hash, name, mimeType string, size, uploaderID int64) (*File, error) {
+	return store.CreateFile(&File{
+		Hash:       hash,
+		Name:       name,
+		MimeType:   mimeType,
+		Size:       size,
+		UploaderID: uploaderID,
+	})
+}
+
+// GetFileByID 根据ID获取文件信息
+func GetFileByID(fileID int64) (*File, error) {
+	return store.GetFileByID(fileID)
+}
+
+// CanDownloadFile 判断用户能否下载文件
+//
+// 被未撤回的消息引用的文件所有人都可以下载；还没有发送过的文件只有上传者可以下载；
+// 只被已撤回或已删除的消息引用过的文件不能再下载
+func CanDownloadFile(file *File, userID int64) (bool, error) {
+	visible, total, err := store.CountFileMessages(file.ID)
+	if err != nil {
+		return false, err
+	}
+	if visible > 0 {
+		return true, nil
+	}
+	return total == 0 && userID != 0 && file.UploaderID == userID, nil
+}
@@ -0,0 +1,996 @@
+package models
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 是内存存储，不依赖CGO，应用关闭后数据丢失
+//
+// 所有数据由同一把锁保护，返回给调用方的都是副本
+type MemoryStore struct {
+	mu         sync.RWMutex
+	users      map[int64]*User
+	sessions   map[string]*Session
+	rooms      map[int64]*Room
+	files      map[int64]*File
+	messages   map[int64]*Message
+	reactions  []reaction // 按回应时间排列
+	edits      []*MessageEdit
+	search     *searchIndex
+	reads      map[readKey]*ReadState
+	mentions   map[mention]bool
+	bans       []*Ban
+	audit      []*AuditEntry
+	lastUserID int64
+	lastRoomID int64
+	lastFileID int64
+	lastMsgID  int64
+	lastEditID int64
+	lastBanID  int64
+}
+
+// NewMemoryStore 创建内存存储，并创建默认房间
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		users:    make(map[int64]*User),
+		sessions: make(map[string]*Session),
+		rooms:    make(map[int64]*Room),
+		files:    make(map[int64]*File),
+		messages: make(map[int64]*Message),
+		search:   newSearchIndex(),
+		reads:    make(map[readKey]*ReadState),
+		mentions: make(map[mention]bool),
+	}
+
+	s.rooms[DefaultRoomID] = &Room{
+		ID:        DefaultRoomID,
+		Name:      DefaultRoomName,
+		CreatedAt: time.Now(),
+	}
+	s.lastRoomID = DefaultRoomID
+
+	return s
+}
+
+// 复制用户
+func copyUser(user *User) *User {
+	u := *user
+	return &u
+}
+
+// CreateUser 创建新用户
+func (s *MemoryStore) CreateUser(ip, username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastUserID++
+	user := &User{
+		ID:         s.lastUserID,
+		IP:         ip,
+		LastOnline: time.Now(),
+		Presence:   PresenceOnline,
+	}
+	if username != "" {
+		user.Username = sql.NullString{String: username, Valid: true}
+		user.UsernameStr = username
+	}
+	s.users[user.ID] = user
+
+	return copyUser(user), nil
+}
+
+// GetUserByID 根据ID获取用户
+func (s *MemoryStore) GetUserByID(userID int64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, ErrNoRows
+	}
+	return copyUser(user), nil
+}
+
+// GetUserByLoginName 根据登录名获取用户
+func (s *MemoryStore) GetUserByLoginName(loginName string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if user := s.findLoginName(loginName); user != nil {
+		return copyUser(user), nil
+	}
+	return nil, ErrNoRows
+}
+
+// GetUsersByNames 获取昵称或登录名在names中的用户
+func (s *MemoryStore) GetUsersByNames(names []string) ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	users := make([]*User, 0)
+	for _, user := range s.users {
+		if (user.Username.Valid && wanted[user.Username.String]) ||
+			(user.LoginName.Valid && wanted[user.LoginName.String]) {
+			users = append(users, copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// 查找使用指定登录名的用户，调用方需持有锁
+func (s *MemoryStore) findLoginName(loginName string) *User {
+	for _, user := range s.users {
+		if user.LoginName.Valid && user.LoginName.String == loginName {
+			return user
+		}
+	}
+	return nil
+}
+
+// SetCredentials 设置用户的登录名和密码哈希
+func (s *MemoryStore) SetCredentials(userID int64, loginName, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNoRows
+	}
+	if other := s.findLoginName(loginName); other != nil && other.ID != userID {
+		return ErrLoginNameTaken
+	}
+
+	user.LoginName = sql.NullString{String: loginName, Valid: true}
+	user.LoginNameStr = loginName
+	user.PasswordHash = sql.NullString{String: passwordHash, Valid: true}
+	return nil
+}
+
+// 在锁内修改用户
+func (s *MemoryStore) updateUser(userID int64, update func(user *User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNoRows
+	}
+	update(user)
+	return nil
+}
+
+// UpdateUserIP 记录用户最近一次连接使用的IP
+func (s *MemoryStore) UpdateUserIP(userID int64, ip string) error {
+	return s.updateUser(userID, func(user *User) {
+		user.IP = ip
+	})
+}
+
+// UpdateUsername 更新用户名
+func (s *MemoryStore) UpdateUsername(userID int64, username string) error {
+	return s.updateUser(userID, func(user *User) {
+		user.Username = sql.NullString{String: username, Valid: true}
+		user.UsernameStr = username
+	})
+}
+
+// SetPresence 设置用户的在线状态和状态文字
+func (s *MemoryStore) SetPresence(userID int64, presence, statusText string) error {
+	return s.updateUser(userID, func(user *User) {
+		user.Presence = presence
+		user.StatusText = statusText
+	})
+}
+
+// UpdateLastOnline 更新用户的最后在线时间
+func (s *MemoryStore) UpdateLastOnline(userID int64) error {
+	return s.updateUser(userID, func(user *User) {
+		user.LastOnline = time.Now()
+	})
+}
+
+// GetUsersOnlineSince 获取指定时间之后活跃过的用户
+func (s *MemoryStore) GetUsersOnlineSince(since time.Time) ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0)
+	for _, user := range s.users {
+		if user.LastOnline.After(since) {
+			users = append(users, copyUser(user))
+		}
+	}
+
+	// 按最后在线时间排序（从新到旧）
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].LastOnline.After(users[j].LastOnline)
+	})
+
+	return users, nil
+}
+
+// DeleteInactiveGuests 删除指定时间之前不再活跃、没有会话和消息的访客用户
+func (s *MemoryStore) DeleteInactiveGuests(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := make(map[int64]bool)
+	for _, session := range s.sessions {
+		keep[session.UserID] = true
+	}
+	for _, msg := range s.messages {
+		keep[msg.UserID] = true
+	}
+	for _, r := range s.reactions {
+		keep[r.UserID] = true
+	}
+	for _, ban := range s.bans {
+		keep[ban.UserID] = true
+	}
+
+	var deleted int64
+	for id, user := range s.users {
+		if !user.LoginName.Valid && user.LastOnline.Before(before) && !keep[id] {
+			delete(s.users, id)
+			deleted++
+		}
+	}
+
+	// 已读记录和提及记录随用户一起删除
+	for key := range s.reads {
+		if _, exists := s.users[key.userID]; !exists {
+			delete(s.reads, key)
+		}
+	}
+	for m := range s.mentions {
+		if _, exists := s.users[m.userID]; !exists {
+			delete(s.mentions, m)
+		}
+	}
+
+	return deleted, nil
+}
+
+// CountUsers 获取用户数量
+func (s *MemoryStore) CountUsers() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users), nil
+}
+
+// CreateSession 保存会话
+func (s *MemoryStore) CreateSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	s.sessions[session.Token] = &stored
+	return nil
+}
+
+// GetSession 根据令牌获取会话
+func (s *MemoryStore) GetSession(token string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[token]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	found := *session
+	return &found, nil
+}
+
+// ExtendSession 修改会话过期时间
+func (s *MemoryStore) ExtendSession(token string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, exists := s.sessions[token]; exists {
+		session.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+// DeleteSession 删除会话
+func (s *MemoryStore) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+	return nil
+}
+
+// DeleteExpiredSessions 删除已过期的会话
+func (s *MemoryStore) DeleteExpiredSessions(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+// CreateRoom 创建新房间，房间名称不可重复
+func (s *MemoryStore) CreateRoom(name string, userID int64) (*Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, room := range s.rooms {
+		if room.Name == name {
+			return nil, ErrRoomExists
+		}
+	}
+
+	s.lastRoomID++
+	room := &Room{
+		ID:        s.lastRoomID,
+		Name:      name,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+	}
+	s.rooms[room.ID] = room
+
+	created := *room
+	return &created, nil
+}
+
+// GetRooms 获取所有房间
+func (s *MemoryStore) GetRooms() ([]*Room, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		r := *room
+		rooms = append(rooms, &r)
+	}
+
+	// 按ID排序
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].ID < rooms[j].ID
+	})
+
+	return rooms, nil
+}
+
+// GetRoomByID 根据ID获取房间
+func (s *MemoryStore) GetRoomByID(roomID int64) (*Room, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	room, exists := s.rooms[roomID]
+	if !exists {
+		return nil, ErrRoomNotFound
+	}
+	r := *room
+	return &r, nil
+}
+
+// CreateFile 记录上传的文件信息
+func (s *MemoryStore) CreateFile(file *File) (*File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastFileID++
+	stored := *file
+	stored.ID = s.lastFileID
+	stored.CreatedAt = time.Now()
+	s.files[stored.ID] = &stored
+
+	created := stored
+	return &created, nil
+}
+
+// CountFileMessages 统计引用文件的消息数，visible为其中未撤回的消息数
+func (s *MemoryStore) CountFileMessages(fileID int64) (visible, total int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, msg := range s.messages {
+		if msg.FileID != fileID {
+			continue
+		}
+		total++
+		if msg.Status == MessageStatusNormal {
+			visible++
+		}
+	}
+	return visible, total, nil
+}
+
+// GetFileByID 根据ID获取文件信息
+func (s *MemoryStore) GetFileByID(fileID int64) (*File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, exists := s.files[fileID]
+	if !exists {
+		return nil, ErrFileNotFound
+	}
+	f := *file
+	return &f, nil
+}
+
+// 复制消息并填入发送者当前的用户名，与SQLite模式联表查询的结果一致，调用方需持有锁
+func (s *MemoryStore) messageView(msg *Message) *Message {
+	m := *msg
+	m.Username = sql.NullString{}
+	if user, exists := s.users[m.UserID]; exists {
+		m.Username = user.Username
+	}
+	m.UsernameStr = m.Username.String
+	m.FileNameStr = m.FileName.String
+	m.FileSizeVal = m.FileSize.Int64
+	return &m
+}
+
+// 按条件筛选消息，按ID从早到晚排列，只保留最新的limit条，调用方需持有锁
+func (s *MemoryStore) latestMessages(match func(msg *Message) bool, limit int) []*Message {
+	messages := make([]*Message, 0)
+	for _, msg := range s.messages {
+		if match(msg) {
+			messages = append(messages, s.messageView(msg))
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages
+}
+
+// CreateMessage 保存消息
+func (s *MemoryStore) CreateMessage(msg *Message) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMsgID++
+	stored := *msg
+	stored.ID = s.lastMsgID
+	stored.Status = MessageStatusNormal
+	stored.CreatedAt = time.Now()
+	s.messages[stored.ID] = &stored
+	s.reindex(&stored)
+
+	return s.messageView(&stored), nil
+}
+
+// GetMessageByID 根据ID获取消息
+func (s *MemoryStore) GetMessageByID(messageID int64) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msg, exists := s.messages[messageID]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	return s.messageView(msg), nil
+}
+
+// GetMessages 按分页条件获取指定房间的消息，按时间从早到晚排列
+func (s *MemoryStore) GetMessages(roomID int64, page Page) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pageMessages(func(msg *Message) bool {
+		return msg.RoomID == roomID && msg.RecipientID == 0
+	}, page), nil
+}
+
+// GetMentions 按分页条件获取提到指定用户的消息，按时间从早到晚排列
+func (s *MemoryStore) GetMentions(userID int64, page Page) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mentioned := make(map[int64]bool)
+	for m := range s.mentions {
+		if m.userID == userID {
+			mentioned[m.messageID] = true
+		}
+	}
+	return s.pageMessages(func(msg *Message) bool {
+		return mentioned[msg.ID]
+	}, page), nil
+}
+
+// AddMentions 记录消息提到的用户
+func (s *MemoryStore) AddMentions(messageID int64, userIDs []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userID := range userIDs {
+		s.mentions[mention{messageID: messageID, userID: userID}] = true
+	}
+	return nil
+}
+
+// 按分页条件获取满足条件的消息，按时间从早到晚排列，调用方需持有锁
+func (s *MemoryStore) pageMessages(match func(msg *Message) bool, page Page) []*Message {
+	// 紧接在After之后的消息，取最早的limit条
+	if page.After != 0 {
+		messages := s.latestMessages(func(msg *Message) bool {
+			return match(msg) && msg.ID > page.After
+		}, len(s.messages))
+		if len(messages) > page.Limit {
+			messages = messages[:page.Limit]
+		}
+		return messages
+	}
+
+	return s.latestMessages(func(msg *Message) bool {
+		return match(msg) && (page.Before == 0 || msg.ID < page.Before)
+	}, page.Limit)
+}
+
+// GetConversation 获取两个用户之间最近的私信，按时间从早到晚排列
+func (s *MemoryStore) GetConversation(userID, peerID int64, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latestMessages(func(msg *Message) bool {
+		return (msg.UserID == userID && msg.RecipientID == peerID) ||
+			(msg.UserID == peerID && msg.RecipientID == userID)
+	}, limit), nil
+}
+
+// 判断消息是roomIDs中房间的消息或用户参与的私信
+func inRoomsOrDirect(msg *Message, userID int64, roomIDs []int64) bool {
+	if msg.RecipientID != 0 {
+		return msg.UserID == userID || msg.RecipientID == userID
+	}
+	for _, roomID := range roomIDs {
+		if msg.RoomID == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMessagesAfter 获取多个房间的消息和用户私信中ID大于afterID的最早limit条，按时间从早到晚排列
+func (s *MemoryStore) GetMessagesAfter(userID int64, roomIDs []int64, afterID int64, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pageMessages(func(msg *Message) bool {
+		return inRoomsOrDirect(msg, userID, roomIDs)
+	}, Page{After: afterID, Limit: limit}), nil
+}
+
+// GetChangedMessages 获取多个房间的消息和用户私信中ID不大于maxID、在since及之后被编辑或撤回的消息，按时间从早到晚排列
+func (s *MemoryStore) GetChangedMessages(userID int64, roomIDs []int64, maxID int64, since time.Time, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changedSince := func(t *time.Time) bool {
+		return t != nil && !t.Before(since)
+	}
+	messages := s.latestMessages(func(msg *Message) bool {
+		return msg.ID <= maxID && inRoomsOrDirect(msg, userID, roomIDs) &&
+			(changedSince(msg.EditedAt) || changedSince(msg.RecalledAt))
+	}, len(s.messages))
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// 更新消息的搜索索引，调用方需持有锁
+func (s *MemoryStore) reindex(msg *Message) {
+	if searchable(msg) {
+		s.search.add(msg.ID, searchBody(msg))
+	} else {
+		s.search.remove(msg.ID)
+	}
+}
+
+// SearchMessages 搜索房间消息，按相关度从高到低排列，相关度相同时较新的在前
+func (s *MemoryStore) SearchMessages(query SearchQuery) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scores := s.search.search(query.Terms)
+	matched := make([]*Message, 0, len(scores))
+	for id := range scores {
+		if msg := s.messages[id]; query.matchFilters(msg) {
+			matched = append(matched, msg)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if scores[a.ID] != scores[b.ID] {
+			return scores[a.ID] > scores[b.ID]
+		}
+		return a.ID > b.ID
+	})
+
+	messages := make([]*Message, 0, query.Limit)
+	for i := query.Offset; i < len(matched) && len(messages) < query.Limit; i++ {
+		messages = append(messages, s.messageView(matched[i]))
+	}
+	return messages, nil
+}
+
+// GetReplies 获取回复指定消息的最近的消息，按时间从早到晚排列
+func (s *MemoryStore) GetReplies(parentID int64, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latestMessages(func(msg *Message) bool {
+		return msg.ReplyTo == parentID
+	}, limit), nil
+}
+
+// CountReplies 获取多条消息各自的回复数量，没有回复的消息不在结果中
+func (s *MemoryStore) CountReplies(messageIDs []int64) (map[int64]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[int64]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	counts := make(map[int64]int)
+	for _, msg := range s.messages {
+		if msg.ReplyTo != 0 && wanted[msg.ReplyTo] {
+			counts[msg.ReplyTo]++
+		}
+	}
+	return counts, nil
+}
+
+// SetMessageStatus 修改消息状态，改为已撤回时记录撤回时间
+func (s *MemoryStore) SetMessageStatus(messageID int64, status int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, exists := s.messages[messageID]
+	if !exists {
+		return ErrMessageNotFound
+	}
+	msg.Status = status
+	if status == MessageStatusRecalled {
+		now := time.Now()
+		msg.RecalledAt = &now
+	}
+	s.reindex(msg)
+	return nil
+}
+
+// RemoveMessage 把消息标记为已撤回，并清除内容、引用的文件和编辑历史
+func (s *MemoryStore) RemoveMessage(messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, exists := s.messages[messageID]
+	if !exists {
+		return ErrMessageNotFound
+	}
+	now := time.Now()
+	msg.Status = MessageStatusRecalled
+	msg.Content = ""
+	msg.FileID = 0
+	msg.FileName = sql.NullString{}
+	msg.FileSize = sql.NullInt64{}
+	msg.RecalledAt = &now
+	s.reindex(msg)
+
+	edits := s.edits[:0]
+	for _, edit := range s.edits {
+		if edit.MessageID != messageID {
+			edits = append(edits, edit)
+		}
+	}
+	s.edits = edits
+	return nil
+}
+
+// EditMessage 保存消息编辑前的内容，然后修改消息内容和编辑时间
+func (s *MemoryStore) EditMessage(messageID int64, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, exists := s.messages[messageID]
+	if !exists {
+		return ErrMessageNotFound
+	}
+
+	now := time.Now()
+	s.lastEditID++
+	s.edits = append(s.edits, &MessageEdit{
+		ID:        s.lastEditID,
+		MessageID: messageID,
+		Content:   msg.Content,
+		EditedAt:  now,
+	})
+	msg.Content = content
+	msg.EditedAt = &now
+	s.reindex(msg)
+	return nil
+}
+
+// GetMessageEdits 获取消息的历史版本，按编辑时间从早到晚排列
+func (s *MemoryStore) GetMessageEdits(messageID int64) ([]*MessageEdit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	edits := make([]*MessageEdit, 0)
+	for _, edit := range s.edits {
+		if edit.MessageID == messageID {
+			e := *edit
+			edits = append(edits, &e)
+		}
+	}
+	return edits, nil
+}
+
+// CountMessages 获取消息数量
+func (s *MemoryStore) CountMessages() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.messages), nil
+}
+
+// ToggleReaction 添加或取消表情回应，返回是否为添加
+func (s *MemoryStore) ToggleReaction(messageID, userID int64, emoji string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.reactions {
+		if r.MessageID == messageID && r.UserID == userID && r.Emoji == emoji {
+			s.reactions = append(s.reactions[:i], s.reactions[i+1:]...)
+			return false, nil
+		}
+	}
+
+	s.reactions = append(s.reactions, reaction{MessageID: messageID, UserID: userID, Emoji: emoji})
+	return true, nil
+}
+
+// GetReactions 获取多条消息的表情回应汇总
+func (s *MemoryStore) GetReactions(messageIDs []int64) (map[int64][]ReactionCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[int64]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	var reactions []reaction
+	for _, r := range s.reactions {
+		if wanted[r.MessageID] {
+			reactions = append(reactions, r)
+		}
+	}
+
+	return aggregateReactions(reactions), nil
+}
+
+// 已读记录的键
+type readKey struct {
+	userID, roomID, peerID int64
+}
+
+// MarkRead 把已读位置推进到state.LastReadID，已读位置不会后退，返回是否有变化
+func (s *MemoryStore) MarkRead(state *ReadState) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := readKey{state.UserID, state.RoomID, state.PeerID}
+	if existing, exists := s.reads[key]; exists && existing.LastReadID >= state.LastReadID {
+		return false, nil
+	}
+
+	stored := *state
+	stored.UpdatedAt = time.Now()
+	s.reads[key] = &stored
+	return true, nil
+}
+
+// 按条件复制已读记录，按已读位置从新到旧排列，调用方需持有锁
+func (s *MemoryStore) findReadStates(match func(state *ReadState) bool) []*ReadState {
+	states := make([]*ReadState, 0)
+	for _, state := range s.reads {
+		if match(state) {
+			st := *state
+			states = append(states, &st)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].LastReadID > states[j].LastReadID
+	})
+	return states
+}
+
+// GetRoomReadStates 获取房间中所有用户的已读位置
+func (s *MemoryStore) GetRoomReadStates(roomID int64) ([]*ReadState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findReadStates(func(state *ReadState) bool {
+		return state.RoomID == roomID && state.PeerID == 0
+	}), nil
+}
+
+// GetConversationReadStates 获取私信会话双方的已读位置
+func (s *MemoryStore) GetConversationReadStates(userID, peerID int64) ([]*ReadState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findReadStates(func(state *ReadState) bool {
+		return state.RoomID == 0 &&
+			((state.UserID == userID && state.PeerID == peerID) || (state.UserID == peerID && state.PeerID == userID))
+	}), nil
+}
+
+// CountUnread 统计用户在各房间和各私信会话中已读位置之后的消息数量，不含自己发送的消息
+func (s *MemoryStore) CountUnread(userID int64) (*UnreadCounts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := newUnreadCounts()
+	lastRead := func(roomID, peerID int64) int64 {
+		if state, exists := s.reads[readKey{userID, roomID, peerID}]; exists {
+			return state.LastReadID
+		}
+		return 0
+	}
+
+	for _, msg := range s.messages {
+		if msg.UserID == userID || !countsAsUnread(msg) {
+			continue
+		}
+		switch {
+		case msg.RecipientID == 0:
+			if msg.ID > lastRead(msg.RoomID, 0) {
+				counts.Rooms[msg.RoomID]++
+			}
+		case msg.RecipientID == userID:
+			if msg.ID > lastRead(0, msg.UserID) {
+				counts.Direct[msg.UserID]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// 消息提到的用户
+type mention struct {
+	messageID, userID int64
+}
+
+// SetAdmin 设置用户是否为管理员
+func (s *MemoryStore) SetAdmin(userID int64, admin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNoRows
+	}
+	user.IsAdmin = admin
+	return nil
+}
+
+// SetMutedUntil 设置用户的禁言截止时间，until为空时解除禁言
+func (s *MemoryStore) SetMutedUntil(userID int64, until *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNoRows
+	}
+	if until == nil {
+		user.MutedUntil = nil
+		return nil
+	}
+	t := *until
+	user.MutedUntil = &t
+	return nil
+}
+
+// CreateBan 保存封禁记录
+func (s *MemoryStore) CreateBan(ban *Ban) (*Ban, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastBanID++
+	stored := *ban
+	stored.ID = s.lastBanID
+	stored.CreatedAt = time.Now()
+	s.bans = append(s.bans, &stored)
+
+	created := stored
+	return &created, nil
+}
+
+// GetActiveBans 获取在指定时间仍然生效的封禁记录，按创建时间从新到旧排列
+func (s *MemoryStore) GetActiveBans(now time.Time) ([]*Ban, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bans := make([]*Ban, 0)
+	for i := len(s.bans) - 1; i >= 0; i-- {
+		ban := s.bans[i]
+		if ban.ExpiresAt == nil || ban.ExpiresAt.After(now) {
+			b := *ban
+			bans = append(bans, &b)
+		}
+	}
+	return bans, nil
+}
+
+// GetBanByID 根据ID获取封禁记录
+func (s *MemoryStore) GetBanByID(banID int64) (*Ban, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, ban := range s.bans {
+		if ban.ID == banID {
+			b := *ban
+			return &b, nil
+		}
+	}
+	return nil, ErrBanNotFound
+}
+
+// DeleteBan 删除封禁记录
+func (s *MemoryStore) DeleteBan(banID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, ban := range s.bans {
+		if ban.ID == banID {
+			s.bans = append(s.bans[:i], s.bans[i+1:]...)
+			return nil
+		}
+	}
+	return ErrBanNotFound
+}
+
+// AddAuditEntry 保存管理操作记录
+func (s *MemoryStore) AddAuditEntry(entry *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *entry
+	stored.ID = int64(len(s.audit) + 1)
+	stored.CreatedAt = time.Now()
+	s.audit = append(s.audit, &stored)
+	return nil
+}
+
+// GetAuditLog 获取最近的管理操作记录，按时间从新到旧排列
+func (s *MemoryStore) GetAuditLog(limit int) ([]*AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]*AuditEntry, 0, limit)
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := *s.audit[i]
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 提及相关限制
+const (
+	maxMentions     = 20 // 一条消息最多提到的用户数量
+	maxMentionRunes = 32 // @之后名称的最大长度
+)
+
+// 结束名称的标点
+const mentionTerminators = ",!?:;，。！？：；、()（）"
+
+// ParseMentions 解析文本中的@名称，名称到空白、标点或下一个@为止，去掉末尾的英文句点和重复的名称
+//
+// @前面是英文字母或数字时不算提及，避免把邮件地址当作提及
+func ParseMentions(content string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+		if i > 0 && runes[i-1] < utf8.RuneSelf && (unicode.IsLetter(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && runes[end] != '@' && !unicode.IsSpace(runes[end]) &&
+			!strings.ContainsRune(mentionTerminators, runes[end]) {
+			end++
+		}
+		name := strings.TrimRight(string(runes[i+1:end]), ".")
+		i = end - 1
+
+		if name == "" || utf8.RuneCountInString(name) > maxMentionRunes || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// CreateMentions 记录房间文本消息中@提到的用户，返回被提到的用户，不包括消息作者
+//
+// 名称按昵称或登录名匹配，多个用户使用相同昵称时都会被提到
+func CreateMentions(msg *Message) ([]*User, error) {
+	if msg.Type != MessageTypeText || msg.RecipientID != 0 {
+		return []*User{}, nil
+	}
+
+	names := ParseMentions(msg.Content)
+	if len(names) == 0 {
+		return []*User{}, nil
+	}
+
+	users, err := store.GetUsersByNames(names)
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned := make([]*User, 0, len(users))
+	userIDs := make([]int64, 0, len(users))
+	for _, user := range users {
+		if user.ID == msg.UserID {
+			continue
+		}
+		mentioned = append(mentioned, user)
+		userIDs = append(userIDs, user.ID)
+	}
+	if len(userIDs) == 0 {
+		return mentioned, nil
+	}
+
+	if err := store.AddMentions(msg.ID, userIDs); err != nil {
+		return nil, err
+	}
+	return mentioned, nil
+}
+
+// GetMentions 按分页条件获取提到指定用户的消息，按时间从早到晚排列，返回值与GetMessagePage相同
+func GetMentions(userID int64, page Page) ([]*Message, int64, error) {
+	return loadPage(page, func(page Page) ([]*Message, error) {
+		return store.GetMentions(userID, page)
+	})
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	// 超过数量上限的提及只保留前maxMentions个
+	many := make([]string, maxMentions+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("@u%d", i)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"没有提及", "大家好", []string{}},
+		{"单个提及", "@alice 你好", []string{"alice"}},
+		{"中文名称", "请@小明 看一下", []string{"小明"}},
+		{"中文标点结束名称", "@小明，你好", []string{"小明"}},
+		{"英文标点结束名称", "@bob: hi", []string{"bob"}},
+		{"去掉末尾的句点", "see you @bob.", []string{"bob"}},
+		{"保留名称中间的句点", "@bob.smith hi", []string{"bob.smith"}},
+		{"多个提及", "@alice @bob", []string{"alice", "bob"}},
+		{"紧跟名称的@不算新的提及", "@alice@bob", []string{"alice"}},
+		{"重复的名称", "@alice @alice", []string{"alice"}},
+		{"邮件地址", "mail alice@example.com", []string{}},
+		{"中文之后的提及", "你好@alice", []string{"alice"}},
+		{"只有@", "@ @", []string{}},
+		{"名称长度上限", "@" + strings.Repeat("名", maxMentionRunes), []string{strings.Repeat("名", maxMentionRunes)}},
+		{"名称超过长度上限", "@" + strings.Repeat("名", maxMentionRunes+1), []string{}},
+		{"数量上限", strings.Join(many, " "), mentionNames(many[:maxMentions])},
+	}
+	for _, tt := range tests {
+		got := ParseMentions(tt.content)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ParseMentions(%q) = %q，应为 %q", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
+
+// 去掉@得到名称
+func mentionNames(mentions []string) []string {
+	names := make([]string, len(mentions))
+	for i, mention := range mentions {
+		names[i] = strings.TrimPrefix(mention, "@")
+	}
+	return names
+}
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 按版本号命名的迁移脚本，例如 0001_initial.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew 表示数据库由更新版本的程序创建，当前程序无法安全使用
+var ErrSchemaTooNew = errors.New("数据库版本高于程序支持的版本")
+
+// Migration 是一个数据库结构升级步骤
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationState 表示迁移在数据库中的执行情况
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// 在引入版本管理之前，旧版本通过ALTER TABLE逐步添加的列，接管旧数据库时补齐
+var legacyColumns = []struct {
+	table, column, definition string
+}{
+	{"messages", "status", "INTEGER DEFAULT 0"},
+	{"messages", "file_name", "TEXT"},
+	{"messages", "file_size", "INTEGER"},
+	{"messages", "room_id", "INTEGER DEFAULT 1"},
+	{"messages", "recipient_id", "INTEGER DEFAULT 0"},
+	{"messages", "file_id", "INTEGER DEFAULT 0"},
+	{"users", "login_name", "TEXT"},
+	{"users", "password_hash", "TEXT"},
+}
+
+// loadMigrations 读取内嵌的迁移脚本，按版本号排序
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, label, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("迁移脚本文件名无效: %s", entry.Name())
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: label, SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("迁移脚本版本不连续: 缺少版本 %d", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// 确保版本表存在
+func ensureVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+// 获取已执行的迁移及执行时间
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// 检查表是否存在
+func tableExists(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, table string) (bool, error) {
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+// 获取表中已有的列
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// adoptLegacySchema 补齐旧数据库缺少的列，使初始迁移可以在其上执行
+func adoptLegacySchema(tx *sql.Tx) error {
+	for _, c := range legacyColumns {
+		exists, err := tableExists(tx, c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		columns, err := tableColumns(tx, c.table)
+		if err != nil {
+			return err
+		}
+		if columns[c.column] {
+			continue
+		}
+
+		log.Printf("旧数据库缺少列 %s.%s，正在添加", c.table, c.column)
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在事务中执行一个迁移并记录版本
+func applyMigration(db *sql.DB, m Migration, legacy bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if legacy {
+		if err := adoptLegacySchema(tx); err != nil {
+			return fmt.Errorf("接管旧数据库失败: %w", err)
+		}
+	}
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("执行迁移 %04d_%s 失败: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrationStates 对比内嵌迁移和数据库中的记录
+func migrationStates(db *sql.DB) ([]MigrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	hasVersionTable, err := tableExists(db, "schema_version")
+	if err != nil {
+		return nil, err
+	}
+	if hasVersionTable {
+		if applied, err = appliedMigrations(db); err != nil {
+			return nil, err
+		}
+	}
+
+	// 数据库中有程序不认识的版本，说明数据库由更新版本的程序升级过
+	for version := range applied {
+		if version > len(migrations) {
+			return nil, fmt.Errorf("%w: 数据库版本 %d，程序支持到版本 %d", ErrSchemaTooNew, version, len(migrations))
+		}
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		states = append(states, MigrationState{Migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+	return states, nil
+}
+
+// migrateUp 依次执行尚未执行的迁移，返回本次执行的迁移
+func migrateUp(db *sql.DB) ([]Migration, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+
+	states, err := migrationStates(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// 没有版本记录但已有用户表的是引入版本管理之前创建的数据库
+	legacy := false
+	if len(states) > 0 && !states[0].Applied {
+		legacy, err = tableExists(db, "users")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var done []Migration
+	for _, state := range states {
+		if state.Applied {
+			continue
+		}
+		if err := applyMigration(db, state.Migration, legacy && state.Version == 1); err != nil {
+			return done, err
+		}
+		log.Printf("已执行数据库迁移 %04d_%s", state.Version, state.Name)
+		done = append(done, state.Migration)
+	}
+	return done, nil
+}
+
+// MigrationStatus 获取SQLite数据库的迁移状态，不修改数据库
+func MigrationStatus(path string) ([]MigrationState, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return migrationStates(db)
+}
+
+// MigrateUp 把SQLite数据库升级到最新版本
+func MigrateUp(path string) ([]Migration, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return migrateUp(db)
+}
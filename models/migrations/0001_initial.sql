@@ -0,0 +1,53 @@
+-- 初始表结构
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ip TEXT NOT NULL,
+	username TEXT,
+	login_name TEXT,
+	password_hash TEXT,
+	last_online TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 登录名唯一
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_login_name ON users (login_name);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	content TEXT NOT NULL,
+	type INTEGER DEFAULT 0,
+	status INTEGER DEFAULT 0,
+	file_name TEXT,
+	file_size INTEGER,
+	room_id INTEGER DEFAULT 1,
+	recipient_id INTEGER DEFAULT 0,
+	file_id INTEGER DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	created_by INTEGER DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 文件内容按哈希存放在磁盘上
+CREATE TABLE IF NOT EXISTS files (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash TEXT NOT NULL,
+	name TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	uploader_id INTEGER DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
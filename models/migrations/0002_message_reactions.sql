@@ -0,0 +1,8 @@
+-- 消息的表情回应，同一用户对同一消息可以使用多个不同表情
+CREATE TABLE message_reactions (
+	message_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	emoji TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (message_id, user_id, emoji)
+);
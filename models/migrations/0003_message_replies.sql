@@ -0,0 +1,3 @@
+-- 消息回复，reply_to为被回复消息的ID，为0时表示不是回复
+ALTER TABLE messages ADD COLUMN reply_to INTEGER DEFAULT 0;
+CREATE INDEX idx_messages_reply_to ON messages(reply_to);
@@ -0,0 +1,11 @@
+-- 消息编辑，edited_at为最后一次编辑的时间，为空时表示未编辑过
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+
+-- 消息的历史版本，content为编辑前的内容
+CREATE TABLE message_edits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id);
@@ -0,0 +1,9 @@
+-- 用户在每个房间或私信会话中已读到的消息，房间为peer_id=0，私信为room_id=0
+CREATE TABLE read_state (
+	user_id INTEGER NOT NULL,
+	room_id INTEGER NOT NULL DEFAULT 0,
+	peer_id INTEGER NOT NULL DEFAULT 0,
+	last_read_id INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, room_id, peer_id)
+);
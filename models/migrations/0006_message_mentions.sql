@@ -0,0 +1,8 @@
+-- 消息中@提到的用户
+CREATE TABLE message_mentions (
+	message_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_mentions_user_id ON message_mentions (user_id, message_id);
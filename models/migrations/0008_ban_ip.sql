@@ -0,0 +1,2 @@
+-- 按IP或网段封禁，ip为单个IP或CIDR网段，为空时只按user_id封禁
+ALTER TABLE bans ADD COLUMN ip TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- 消息撤回时间，为空时表示未撤回，用于断线重连时补发撤回通知
+ALTER TABLE messages ADD COLUMN recalled_at TIMESTAMP;
@@ -0,0 +1,3 @@
+-- 用户自己设置的在线状态：online、away、busy或dnd，以及自定义的状态文字
+ALTER TABLE users ADD COLUMN presence TEXT NOT NULL DEFAULT 'online';
+ALTER TABLE users ADD COLUMN status_text TEXT NOT NULL DEFAULT '';
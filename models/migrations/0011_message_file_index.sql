@@ -0,0 +1,2 @@
+-- 下载文件时按file_id查找引用它的消息
+CREATE INDEX IF NOT EXISTS idx_messages_file_id ON messages(file_id);
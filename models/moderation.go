@@ -0,0 +1,350 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// 管理操作相关错误
+var (
+	ErrMuted          = errors.New("你已被禁言")
+	ErrModerateSelf   = errors.New("不能对自己执行该操作")
+	ErrModerateAdmin  = errors.New("不能对管理员执行该操作")
+	ErrInvalidMute    = errors.New("禁言时长必须大于0")
+	ErrInvalidBanTime = errors.New("封禁时长不能为负数")
+	ErrInvalidBanIP   = errors.New("IP地址或网段格式无效")
+	ErrBanTarget      = errors.New("需要指定用户ID或IP中的一个")
+)
+
+// 管理操作类型
+const (
+	AuditDeleteMessage = "delete_message"
+	AuditKick          = "kick"
+	AuditBan           = "ban"
+	AuditUnban         = "unban"
+	AuditMute          = "mute"
+	AuditUnmute        = "unmute"
+	AuditTitle         = "title"
+)
+
+// Ban 是一条封禁记录
+type Ban struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"` // 按用户封禁时为用户ID，否则为0
+	IP        string     `json:"ip"`      // 按IP封禁时为单个IP或CIDR网段，否则为空
+	Reason    string     `json:"reason"`
+	CreatedBy int64      `json:"created_by"` // 执行封禁的管理员
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"` // 为空表示永久封禁
+}
+
+// Matches 判断封禁是否适用于指定的用户和IP，userID为0表示未知用户
+func (b *Ban) Matches(userID int64, ip string) bool {
+	if b.UserID != 0 && b.UserID == userID {
+		return true
+	}
+	if b.IP == "" {
+		return false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if strings.Contains(b.IP, "/") {
+		_, network, err := net.ParseCIDR(b.IP)
+		return err == nil && network.Contains(addr)
+	}
+	return net.ParseIP(b.IP).Equal(addr)
+}
+
+// 规范化封禁的IP或CIDR网段，网段中的主机位会被清零
+func normalizeBanIP(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if strings.Contains(text, "/") {
+		_, network, err := net.ParseCIDR(text)
+		if err != nil {
+			return "", ErrInvalidBanIP
+		}
+		return network.String(), nil
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return "", ErrInvalidBanIP
+	}
+	return ip.String(), nil
+}
+
+// AuditEntry 是一条管理操作记录
+type AuditEntry struct {
+	ID           int64     `json:"id"`
+	AdminID      int64     `json:"admin_id"` // 执行操作的管理员，系统自动执行时为0
+	Action       string    `json:"action"`
+	TargetUserID int64     `json:"target_user_id"` // 被操作的用户，没有时为0
+	MessageID    int64     `json:"message_id"`     // 被操作的消息，没有时为0
+	Detail       string    `json:"detail"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// 把配置中的登录名对应的已注册用户设为管理员
+func bootstrapAdmins(loginNames []string) {
+	for _, name := range loginNames {
+		user, err := store.GetUserByLoginName(name)
+		if err != nil {
+			continue
+		}
+		if user.IsAdmin {
+			continue
+		}
+		if err := store.SetAdmin(user.ID, true); err != nil {
+			log.Printf("设置管理员 %s 失败: %v", name, err)
+			continue
+		}
+		log.Printf("已将 %s 设为管理员", name)
+	}
+}
+
+// 判断登录名是否在配置的管理员列表中
+func isConfiguredAdmin(loginName string) bool {
+	for _, name := range settings.Admins {
+		if name == loginName {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMuted 判断用户当前是否处于禁言中
+func (u *User) IsMuted() bool {
+	return u.MutedUntil != nil && time.Now().Before(*u.MutedUntil)
+}
+
+// 检查用户是否可以发言，禁言中返回ErrMuted
+func checkNotMuted(userID int64) error {
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.IsMuted() {
+		return ErrMuted
+	}
+	return nil
+}
+
+// 检查管理员是否可以操作目标用户，返回目标用户
+func checkModerationTarget(adminID, userID int64) (*User, error) {
+	if adminID == userID {
+		return nil, ErrModerateSelf
+	}
+	target, err := store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if target.IsAdmin {
+		return nil, ErrModerateAdmin
+	}
+	return target, nil
+}
+
+// 记录管理操作，记录失败只写日志，不影响已经完成的操作
+func recordAudit(entry *AuditEntry) {
+	if err := store.AddAuditEntry(entry); err != nil {
+		log.Printf("记录管理操作失败: %v", err)
+	}
+}
+
+// DeleteMessage 管理员删除任意消息，消息标记为已撤回并清除内容、引用的文件和编辑历史
+func DeleteMessage(adminID, messageID int64) (*Message, error) {
+	msg, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.RemoveMessage(messageID); err != nil {
+		return nil, err
+	}
+
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditDeleteMessage, TargetUserID: msg.UserID, MessageID: messageID})
+	return msg, nil
+}
+
+// KickUser 记录管理员把用户移出聊天室，断开连接由调用方完成
+func KickUser(adminID, userID int64) (*User, error) {
+	target, err := checkModerationTarget(adminID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditKick, TargetUserID: userID})
+	return target, nil
+}
+
+// 设置封禁的过期时间并保存，返回用于管理操作记录的描述
+func saveBan(ban *Ban, duration time.Duration) (*Ban, string, error) {
+	detail := "永久封禁"
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		ban.ExpiresAt = &expiresAt
+		detail = "封禁" + FormatDuration(duration)
+	}
+	if ban.Reason != "" {
+		detail += "：" + ban.Reason
+	}
+
+	ban, err := store.CreateBan(ban)
+	if err != nil {
+		return nil, "", err
+	}
+	return ban, detail, nil
+}
+
+// BanUser 封禁用户，duration为0时永久封禁
+func BanUser(adminID, userID int64, duration time.Duration, reason string) (*User, *Ban, error) {
+	if duration < 0 {
+		return nil, nil, ErrInvalidBanTime
+	}
+	target, err := checkModerationTarget(adminID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ban, detail, err := saveBan(&Ban{UserID: userID, Reason: reason, CreatedBy: adminID}, duration)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditBan, TargetUserID: userID, Detail: detail})
+	return target, ban, nil
+}
+
+// BanIP 按单个IP或CIDR网段封禁，duration为0时永久封禁
+//
+// adminIP是管理员当前使用的IP，封禁范围包含该IP时返回ErrModerateSelf
+func BanIP(adminID int64, adminIP, ip string, duration time.Duration, reason string) (*Ban, error) {
+	if duration < 0 {
+		return nil, ErrInvalidBanTime
+	}
+	ip, err := normalizeBanIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	ban := &Ban{IP: ip, Reason: reason, CreatedBy: adminID}
+	if ban.Matches(0, adminIP) {
+		return nil, ErrModerateSelf
+	}
+
+	ban, detail, err := saveBan(ban, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditBan, Detail: "IP " + ip + " " + detail})
+	return ban, nil
+}
+
+// GetActiveBans 获取当前生效的封禁记录，按创建时间从新到旧排列
+func GetActiveBans() ([]*Ban, error) {
+	return store.GetActiveBans(time.Now())
+}
+
+// FindBan 查找适用于用户或IP的生效封禁，没有封禁时返回nil
+func FindBan(userID int64, ip string) (*Ban, error) {
+	bans, err := store.GetActiveBans(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, ban := range bans {
+		if ban.Matches(userID, ip) {
+			return ban, nil
+		}
+	}
+	return nil, nil
+}
+
+// RemoveBan 解除封禁，返回被删除的封禁记录
+func RemoveBan(adminID, banID int64) (*Ban, error) {
+	ban, err := store.GetBanByID(banID)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.DeleteBan(banID); err != nil {
+		return nil, err
+	}
+
+	detail := fmt.Sprintf("解除封禁#%d", ban.ID)
+	if ban.IP != "" {
+		detail += "，IP " + ban.IP
+	}
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditUnban, TargetUserID: ban.UserID, Detail: detail})
+	return ban, nil
+}
+
+// MuteUser 禁言用户，重复禁言时以最后一次的时长为准
+func MuteUser(adminID, userID int64, duration time.Duration) (*User, error) {
+	if duration <= 0 {
+		return nil, ErrInvalidMute
+	}
+	target, err := checkModerationTarget(adminID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	until := time.Now().Add(duration)
+	if err := store.SetMutedUntil(userID, &until); err != nil {
+		return nil, err
+	}
+
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditMute, TargetUserID: userID, Detail: "禁言" + FormatDuration(duration)})
+	return target, nil
+}
+
+// AutoMute 因发送过于频繁自动禁言用户，管理员和已在禁言中的用户不会被禁言，此时返回false
+func AutoMute(userID int64, duration time.Duration) (*User, bool, error) {
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if user.IsAdmin || user.IsMuted() {
+		return user, false, nil
+	}
+
+	until := time.Now().Add(duration)
+	if err := store.SetMutedUntil(userID, &until); err != nil {
+		return nil, false, err
+	}
+
+	recordAudit(&AuditEntry{Action: AuditMute, TargetUserID: userID, Detail: "发送过于频繁，自动禁言" + FormatDuration(duration)})
+	return user, true, nil
+}
+
+// UnmuteUser 解除禁言
+func UnmuteUser(adminID, userID int64) (*User, error) {
+	target, err := checkModerationTarget(adminID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SetMutedUntil(userID, nil); err != nil {
+		return nil, err
+	}
+
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditUnmute, TargetUserID: userID})
+	return target, nil
+}
+
+// RecordTitleChange 记录管理员修改聊天室名称
+func RecordTitleChange(adminID int64, title string) {
+	recordAudit(&AuditEntry{AdminID: adminID, Action: AuditTitle, Detail: fmt.Sprintf("修改聊天室名称为：%s", title)})
+}
+
+// GetAuditLog 获取最近的管理操作记录，按时间从新到旧排列
+func GetAuditLog(limit int) ([]*AuditEntry, error) {
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return store.GetAuditLog(limit)
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeBanIP(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"10.0.0.5", "10.0.0.5", nil},
+		{" 10.0.0.5 ", "10.0.0.5", nil},
+		{"::ffff:10.0.0.5", "10.0.0.5", nil},
+		{"2001:DB8::1", "2001:db8::1", nil},
+		{"10.0.0.7/24", "10.0.0.0/24", nil},
+		{"10.0.0.5/32", "10.0.0.5/32", nil},
+		{"0.0.0.0/0", "0.0.0.0/0", nil},
+		{"::ffff:10.0.0.0/120", "10.0.0.0/24", nil},
+		{"2001:db8::1/32", "2001:db8::/32", nil},
+		{"", "", ErrInvalidBanIP},
+		{"abc", "", ErrInvalidBanIP},
+		{"10.0.0.256", "", ErrInvalidBanIP},
+		{"10.0.0.0/33", "", ErrInvalidBanIP},
+		{"10.0.0.0/", "", ErrInvalidBanIP},
+	}
+	for _, tt := range tests {
+		got, err := normalizeBanIP(tt.input)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("normalizeBanIP(%q) = %q, %v，应为 %q, %v", tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestBanMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		ban    Ban
+		userID int64
+		ip     string
+		want   bool
+	}{
+		{"封禁用户", Ban{UserID: 3}, 3, "10.0.0.5", true},
+		{"其他用户", Ban{UserID: 3}, 4, "10.0.0.5", false},
+		{"未知用户", Ban{UserID: 3}, 0, "10.0.0.5", false},
+		{"相同IP", Ban{IP: "10.0.0.5"}, 0, "10.0.0.5", true},
+		{"不同IP", Ban{IP: "10.0.0.5"}, 0, "10.0.0.6", false},
+		{"IPv4映射的IPv6地址", Ban{IP: "10.0.0.5"}, 0, "::ffff:10.0.0.5", true},
+		{"网段的第一个地址", Ban{IP: "10.0.0.0/24"}, 0, "10.0.0.0", true},
+		{"网段的最后一个地址", Ban{IP: "10.0.0.0/24"}, 0, "10.0.0.255", true},
+		{"网段之后的地址", Ban{IP: "10.0.0.0/24"}, 0, "10.0.1.0", false},
+		{"网段之前的地址", Ban{IP: "10.0.0.0/24"}, 0, "9.255.255.255", false},
+		{"网段包含IPv4映射的IPv6地址", Ban{IP: "10.0.0.0/24"}, 0, "::ffff:10.0.0.9", true},
+		{"IPv6网段", Ban{IP: "2001:db8::/32"}, 0, "2001:db8:1::1", true},
+		{"IPv6网段不包含IPv4地址", Ban{IP: "2001:db8::/32"}, 0, "10.0.0.5", false},
+		{"无效的IP", Ban{IP: "10.0.0.0/24"}, 0, "not-an-ip", false},
+		{"空IP", Ban{IP: "10.0.0.5"}, 0, "", false},
+		{"按用户封禁不匹配IP", Ban{UserID: 3}, 0, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.ban.Matches(tt.userID, tt.ip); got != tt.want {
+			t.Errorf("%s: Matches(%d, %q) = %v，应为 %v", tt.name, tt.userID, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestFindBanIgnoresExpired(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+		for _, ban := range []*Ban{
+			{IP: "10.0.0.0/24", ExpiresAt: &past},
+			{UserID: 3, ExpiresAt: &past},
+			{IP: "10.0.1.5", ExpiresAt: &future},
+			{IP: "10.0.2.5"},
+		} {
+			if _, err := store.CreateBan(ban); err != nil {
+				t.Fatalf("保存封禁失败: %v", err)
+			}
+		}
+
+		tests := []struct {
+			userID int64
+			ip     string
+			want   string
+		}{
+			{0, "10.0.0.5", ""},
+			{3, "192.168.0.1", ""},
+			{0, "10.0.1.5", "10.0.1.5"},
+			{0, "10.0.2.5", "10.0.2.5"},
+		}
+		for _, tt := range tests {
+			ban, err := FindBan(tt.userID, tt.ip)
+			if err != nil {
+				t.Fatalf("查找封禁失败: %v", err)
+			}
+			got := ""
+			if ban != nil {
+				got = ban.IP
+			}
+			if got != tt.want {
+				t.Errorf("FindBan(%d, %q) 找到 %q，应为 %q", tt.userID, tt.ip, got, tt.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package models
+
+import "errors"
+
+// 每页消息数量的默认值和上限
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// ErrInvalidPage 表示分页条件无效
+var ErrInvalidPage = errors.New("before和after不能同时使用")
+
+// Page 是按消息ID分页的条件，Before和After都为0时获取最新的消息
+type Page struct {
+	Before int64 // 获取ID小于Before的消息，用于向前翻页
+	After  int64 // 获取ID大于After的消息，用于获取新消息
+	Limit  int
+}
+
+// GetMessagePage 按分页条件获取房间消息，按时间从早到晚排列
+//
+// 返回的游标用于继续向同一方向翻页：向前翻页时为本页最早消息的ID，
+// 获取新消息时为本页最新消息的ID；没有更多消息时为0
+func GetMessagePage(roomID int64, page Page) ([]*Message, int64, error) {
+	return loadPage(page, func(page Page) ([]*Message, error) {
+		return store.GetMessages(roomID, page)
+	})
+}
+
+// 规范分页条件后通过fetch获取一页消息，返回消息和继续翻页的游标
+func loadPage(page Page, fetch func(page Page) ([]*Message, error)) ([]*Message, int64, error) {
+	if page.Before != 0 && page.After != 0 {
+		return nil, 0, ErrInvalidPage
+	}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageLimit
+	}
+	if page.Limit > MaxPageLimit {
+		page.Limit = MaxPageLimit
+	}
+
+	// 多取一条用于判断是否还有更多消息
+	limit := page.Limit
+	page.Limit++
+	messages, err := fetch(page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var cursor int64
+	if len(messages) > limit {
+		if page.After != 0 {
+			messages = messages[:limit]
+			cursor = messages[len(messages)-1].ID
+		} else {
+			messages = messages[1:]
+			cursor = messages[0].ID
+		}
+	}
+
+	messages, err = decorateMessages(messages)
+	if err != nil {
+		return nil, 0, err
+	}
+	return messages, cursor, nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// 用户可以设置的在线状态
+const (
+	PresenceOnline = "online" // 在线
+	PresenceAway   = "away"   // 离开，长时间没有操作时也会自动显示为离开
+	PresenceBusy   = "busy"   // 忙碌
+	PresenceDND    = "dnd"    // 请勿打扰
+)
+
+// MaxStatusTextLength 是自定义状态文字的最大字符数
+const MaxStatusTextLength = 64
+
+// 在线状态相关错误
+var (
+	ErrInvalidPresence = errors.New("在线状态无效")
+	ErrStatusTooLong   = errors.New("状态文字过长")
+)
+
+// IsValidPresence 判断是否为可以设置的在线状态
+func IsValidPresence(presence string) bool {
+	switch presence {
+	case PresenceOnline, PresenceAway, PresenceBusy, PresenceDND:
+		return true
+	}
+	return false
+}
+
+// SetPresence 设置用户的在线状态和自定义状态文字，返回更新后的用户
+func SetPresence(userID int64, presence, statusText string) (*User, error) {
+	if !IsValidPresence(presence) {
+		return nil, ErrInvalidPresence
+	}
+	statusText = strings.TrimSpace(statusText)
+	if utf8.RuneCountInString(statusText) > MaxStatusTextLength {
+		return nil, fmt.Errorf("%w，最多%d个字符", ErrStatusTooLong, MaxStatusTextLength)
+	}
+
+	if err := store.SetPresence(userID, presence, statusText); err != nil {
+		return nil, err
+	}
+	return store.GetUserByID(userID)
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// 表情回应的最大长度（按字符计），足够容纳带修饰符的组合表情
+const maxReactionLength = 8
+
+// ErrInvalidReaction 表示表情回应内容无效
+var ErrInvalidReaction = errors.New("表情回应无效")
+
+// ReactionCount 是消息上某个表情回应的汇总
+type ReactionCount struct {
+	Emoji   string  `json:"emoji"`
+	Count   int     `json:"count"`
+	UserIDs []int64 `json:"user_ids"`
+}
+
+// 表情回应记录，按回应时间排列
+type reaction struct {
+	MessageID int64
+	UserID    int64
+	Emoji     string
+}
+
+// 按消息汇总表情回应，表情按第一次出现的先后排列
+func aggregateReactions(reactions []reaction) map[int64][]ReactionCount {
+	result := make(map[int64][]ReactionCount)
+	for _, r := range reactions {
+		counts := result[r.MessageID]
+		found := false
+		for i := range counts {
+			if counts[i].Emoji == r.Emoji {
+				counts[i].Count++
+				counts[i].UserIDs = append(counts[i].UserIDs, r.UserID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			counts = append(counts, ReactionCount{Emoji: r.Emoji, Count: 1, UserIDs: []int64{r.UserID}})
+		}
+		result[r.MessageID] = counts
+	}
+	return result
+}
+
+// ToggleReaction 添加或取消用户对消息的表情回应，返回是否为添加以及该消息最新的回应汇总
+func ToggleReaction(messageID, userID int64, emoji string) (bool, []ReactionCount, error) {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxReactionLength || strings.ContainsAny(emoji, " \t\r\n") {
+		return false, nil, ErrInvalidReaction
+	}
+
+	added, err := store.ToggleReaction(messageID, userID, emoji)
+	if err != nil {
+		return false, nil, err
+	}
+
+	reactions, err := store.GetReactions([]int64{messageID})
+	if err != nil {
+		return added, nil, err
+	}
+	return added, reactions[messageID], nil
+}
+
+// 为消息列表填充表情回应汇总
+func attachReactions(messages []*Message) ([]*Message, error) {
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+
+	reactions, err := store.GetReactions(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range messages {
+		msg.Reactions = reactions[msg.ID]
+	}
+	return messages, nil
+}
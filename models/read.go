@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrReadOutOfScope 表示确认已读的消息不在指定的房间或会话中
+var ErrReadOutOfScope = errors.New("消息不在当前房间或会话中")
+
+// ReadState 是用户在一个房间或私信会话中已读到的位置
+type ReadState struct {
+	UserID     int64     `json:"user_id"`
+	RoomID     int64     `json:"room_id"` // 房间ID，私信会话为0
+	PeerID     int64     `json:"peer_id"` // 私信会话的对方用户ID，房间为0
+	LastReadID int64     `json:"last_read_id"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// UnreadCounts 是用户在各房间和各私信会话中的未读消息数量，没有未读消息的不在结果中
+type UnreadCounts struct {
+	Rooms  map[int64]int `json:"rooms"`  // 房间ID到未读数量
+	Direct map[int64]int `json:"direct"` // 私信对方用户ID到未读数量
+}
+
+func newUnreadCounts() *UnreadCounts {
+	return &UnreadCounts{
+		Rooms:  make(map[int64]int),
+		Direct: make(map[int64]int),
+	}
+}
+
+// 判断消息是否计入未读数量，系统消息和已撤回的消息不计入
+func countsAsUnread(msg *Message) bool {
+	return msg.Status == MessageStatusNormal && msg.Type != MessageTypeSystem
+}
+
+// MarkRoomRead 确认已读到房间中的指定消息，返回已读位置是否前进
+func MarkRoomRead(userID, roomID, messageID int64) (bool, error) {
+	msg, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return false, err
+	}
+	if msg.RecipientID != 0 || msg.RoomID != roomID {
+		return false, ErrReadOutOfScope
+	}
+
+	return store.MarkRead(&ReadState{UserID: userID, RoomID: roomID, LastReadID: messageID})
+}
+
+// MarkConversationRead 确认已读到与指定用户私信会话中的指定消息，返回已读位置是否前进
+func MarkConversationRead(userID, peerID, messageID int64) (bool, error) {
+	msg, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return false, err
+	}
+	inConversation := (msg.UserID == userID && msg.RecipientID == peerID) ||
+		(msg.UserID == peerID && msg.RecipientID == userID)
+	if !inConversation {
+		return false, ErrReadOutOfScope
+	}
+
+	return store.MarkRead(&ReadState{UserID: userID, PeerID: peerID, LastReadID: messageID})
+}
+
+// GetRoomReadStates 获取房间中所有用户的已读位置
+func GetRoomReadStates(roomID int64) ([]*ReadState, error) {
+	return store.GetRoomReadStates(roomID)
+}
+
+// GetConversationReadStates 获取私信会话双方的已读位置
+func GetConversationReadStates(userID, peerID int64) ([]*ReadState, error) {
+	return store.GetConversationReadStates(userID, peerID)
+}
+
+// GetUnreadCounts 获取用户的未读消息数量
+func GetUnreadCounts(userID int64) (*UnreadCounts, error) {
+	return store.CountUnread(userID)
+}
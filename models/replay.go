@@ -0,0 +1,38 @@
+package models
+
+import "errors"
+
+// ErrReplayGap 表示断线期间错过的消息超过补发上限，客户端需要重新加载
+var ErrReplayGap = errors.New("错过的消息过多，请重新加载")
+
+// GetMissedMessages 获取用户断线期间错过的roomIDs中房间的消息和私信，since是客户端收到的最后一条消息的ID
+//
+// messages是ID大于since的消息，changed是since及之前、在since发送之后被编辑或撤回的消息，
+// 都按时间从早到晚排列；任一部分超过limit条或since对应的消息不存在时返回ErrReplayGap
+func GetMissedMessages(userID int64, roomIDs []int64, since int64, limit int) (messages, changed []*Message, err error) {
+	last, err := store.GetMessageByID(since)
+	if errors.Is(err, ErrMessageNotFound) {
+		return nil, nil, ErrReplayGap
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 多取一条用于判断是否超过上限
+	messages, err = store.GetMessagesAfter(userID, roomIDs, since, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(messages) > limit {
+		return nil, nil, ErrReplayGap
+	}
+
+	changed, err = store.GetChangedMessages(userID, roomIDs, since, last.CreatedAt, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changed) > limit {
+		return nil, nil, ErrReplayGap
+	}
+	return messages, changed, nil
+}
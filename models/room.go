@@ -0,0 +1,35 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// 默认房间，所有连接建立后自动加入
+const (
+	DefaultRoomID   int64 = 1
+	DefaultRoomName       = "大厅"
+)
+
+// Room 表示聊天房间
+type Room struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetRooms 获取所有房间
+func GetRooms() ([]*Room, error) {
+	return store.GetRooms()
+}
+
+// GetRoomByID 根据ID获取房间
+func GetRoomByID(roomID int64) (*Room, error) {
+	return store.GetRoomByID(roomID)
+}
+
+// CreateRoom 创建新房间，房间名称不可重复
+func CreateRoom(name string, userID int64) (*Room, error) {
+	return store.CreateRoom(strings.TrimSpace(name), userID)
+}
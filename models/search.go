@@ -0,0 +1,209 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 搜索相关限制
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	maxSearchTerms     = 8  // 最多使用的关键字数量
+	maxSearchTermRunes = 64 // 单个关键字的最大长度
+	snippetRunes       = 60 // 摘要的大致长度
+)
+
+// ErrEmptySearch 表示没有可用的搜索关键字
+var ErrEmptySearch = errors.New("搜索关键字不能为空")
+
+// SearchQuery 是消息搜索条件，私信、系统消息和已撤回的消息不参与搜索
+type SearchQuery struct {
+	Terms    []string  // 关键字，全部匹配才算命中，由ParseSearchTerms生成
+	SenderID int64     // 发送者，为0时不限
+	Types    []int     // 消息类型，为空时不限
+	RoomID   int64     // 房间，为0时不限
+	Since    time.Time // 不早于该时间，为零值时不限
+	Until    time.Time // 早于该时间，为零值时不限
+	Offset   int
+	Limit    int
+}
+
+// SearchResult 是一条搜索结果，Snippet是转义后的HTML，命中的关键字用<mark>标记
+type SearchResult struct {
+	*Message
+	Snippet string `json:"snippet"`
+}
+
+// ParseSearchTerms 把搜索内容按空白拆分为小写关键字，去掉重复和超出数量的部分
+func ParseSearchTerms(text string) []string {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, field := range strings.Fields(text) {
+		term := lowerText(field)
+		if utf8.RuneCountInString(term) > maxSearchTermRunes {
+			term = string([]rune(term)[:maxSearchTermRunes])
+		}
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// 判断消息是否进入搜索索引
+func searchable(msg *Message) bool {
+	return msg.Status == MessageStatusNormal && msg.Type != MessageTypeSystem
+}
+
+// 消息被索引的文本：图片和文件消息使用文件名，避免匹配到旧版本保存的Base64内容
+func searchBody(msg *Message) string {
+	if msg.Type == MessageTypeImage || msg.Type == MessageTypeFile {
+		return msg.FileName.String
+	}
+	return msg.Content
+}
+
+// 判断消息是否满足关键字以外的搜索条件
+func (q *SearchQuery) matchFilters(msg *Message) bool {
+	if msg.RecipientID != 0 || !searchable(msg) {
+		return false
+	}
+	if q.SenderID != 0 && msg.UserID != q.SenderID {
+		return false
+	}
+	if q.RoomID != 0 && msg.RoomID != q.RoomID {
+		return false
+	}
+	if len(q.Types) > 0 {
+		found := false
+		for _, t := range q.Types {
+			if msg.Type == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && msg.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.CreatedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// SearchMessages 按相关度搜索房间消息，返回结果和下一页的偏移量，没有更多结果时偏移量为0
+func SearchMessages(query SearchQuery) ([]*SearchResult, int, error) {
+	if len(query.Terms) == 0 {
+		return nil, 0, ErrEmptySearch
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	if query.Limit > MaxSearchLimit {
+		query.Limit = MaxSearchLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	// 多取一条用于判断是否还有更多结果
+	limit := query.Limit
+	query.Limit++
+	messages, err := store.SearchMessages(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	next := 0
+	if len(messages) > limit {
+		messages = messages[:limit]
+		next = query.Offset + limit
+	}
+
+	messages, err = decorateMessages(messages)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]*SearchResult, len(messages))
+	for i, msg := range messages {
+		results[i] = &SearchResult{Message: msg, Snippet: makeSnippet(searchBody(msg), query.Terms)}
+	}
+	return results, next, nil
+}
+
+// 生成围绕第一个命中关键字的摘要，转义HTML并用<mark>标记所有命中的关键字
+func makeSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// 标记每个字符是否属于命中的关键字
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != term {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	// 摘要从命中位置之前一小段开始
+	start, end := 0, len(runes)
+	if len(runes) > snippetRunes {
+		if first > snippetRunes/4 {
+			start = first - snippetRunes/4
+		}
+		end = start + snippetRunes
+		if end > len(runes) {
+			end = len(runes)
+			start = end - snippetRunes
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		segment := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			b.WriteString("<mark>" + segment + "</mark>")
+		} else {
+			b.WriteString(segment)
+		}
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
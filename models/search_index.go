@@ -0,0 +1,120 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 建立倒排索引使用的片段长度，与SQLite FTS5的trigram分词器一致
+const gramSize = 3
+
+// searchIndex 是内存模式的分词索引
+//
+// 与SQLite的trigram分词器相同，文本按连续三个字符切分建立倒排索引，
+// 不少于三个字符的关键字先通过索引缩小范围，较短的关键字逐条比较
+type searchIndex struct {
+	docs  map[int64]string              // 小写后的索引文本
+	grams map[string]map[int64]struct{} // 片段到消息ID的倒排表
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:  make(map[int64]string),
+		grams: make(map[string]map[int64]struct{}),
+	}
+}
+
+// 转为小写，与makeSnippet逐字符转换的方式一致
+func lowerText(text string) string {
+	return strings.Map(unicode.ToLower, text)
+}
+
+// 切分文本中所有不重复的片段
+func textGrams(text string) map[string]struct{} {
+	runes := []rune(text)
+	grams := make(map[string]struct{})
+	for i := 0; i+gramSize <= len(runes); i++ {
+		grams[string(runes[i:i+gramSize])] = struct{}{}
+	}
+	return grams
+}
+
+// 添加或替换消息的索引文本
+func (idx *searchIndex) add(id int64, text string) {
+	idx.remove(id)
+
+	text = lowerText(text)
+	idx.docs[id] = text
+	for gram := range textGrams(text) {
+		ids, exists := idx.grams[gram]
+		if !exists {
+			ids = make(map[int64]struct{})
+			idx.grams[gram] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+// 移除消息的索引
+func (idx *searchIndex) remove(id int64) {
+	text, exists := idx.docs[id]
+	if !exists {
+		return
+	}
+
+	delete(idx.docs, id)
+	for gram := range textGrams(text) {
+		delete(idx.grams[gram], id)
+		if len(idx.grams[gram]) == 0 {
+			delete(idx.grams, gram)
+		}
+	}
+}
+
+// 搜索包含全部关键字的消息，返回消息ID和相关度得分
+func (idx *searchIndex) search(terms []string) map[int64]float64 {
+	// 用较长关键字的片段求交集得到候选消息
+	var candidates map[int64]struct{}
+	for _, term := range terms {
+		for gram := range textGrams(term) {
+			ids := idx.grams[gram]
+			if candidates == nil {
+				candidates = make(map[int64]struct{}, len(ids))
+				for id := range ids {
+					candidates[id] = struct{}{}
+				}
+				continue
+			}
+			for id := range candidates {
+				if _, ok := ids[id]; !ok {
+					delete(candidates, id)
+				}
+			}
+		}
+	}
+	if candidates == nil {
+		candidates = make(map[int64]struct{}, len(idx.docs))
+		for id := range idx.docs {
+			candidates[id] = struct{}{}
+		}
+	}
+
+	// 逐条确认包含全部关键字，命中次数越多、文本越短得分越高
+	scores := make(map[int64]float64)
+	for id := range candidates {
+		text := idx.docs[id]
+		hits := 0
+		for _, term := range terms {
+			n := strings.Count(text, term)
+			if n == 0 {
+				hits = 0
+				break
+			}
+			hits += n
+		}
+		if hits > 0 {
+			scores[id] = float64(hits) / (1 + float64(len([]rune(text)))/100)
+		}
+	}
+	return scores
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMakeSnippet(t *testing.T) {
+	quarter := snippetRunes / 4
+
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{"没有命中", "你好世界", []string{"go"}, "你好世界"},
+		{"忽略大小写", "Hello World", []string{"world"}, "Hello <mark>World</mark>"},
+		{"转义HTML", "<b>go</b>", []string{"go"}, "&lt;b&gt;<mark>go</mark>&lt;/b&gt;"},
+		{"重叠的关键字", "gopher go", []string{"go", "gopher"}, "<mark>gopher</mark> <mark>go</mark>"},
+		{"多字节关键字", "今天天气很好", []string{"天气"}, "今天<mark>天气</mark>很好"},
+		{
+			"长文本开头命中",
+			"关键" + strings.Repeat("字", snippetRunes),
+			[]string{"关键"},
+			"<mark>关键</mark>" + strings.Repeat("字", snippetRunes-2) + "…",
+		},
+		{
+			"长文本中间命中",
+			strings.Repeat("文", 50) + "词" + strings.Repeat("字", 50),
+			[]string{"词"},
+			"…" + strings.Repeat("文", quarter) + "<mark>词</mark>" + strings.Repeat("字", snippetRunes-quarter-1) + "…",
+		},
+		{
+			"长文本末尾命中",
+			strings.Repeat("中", 100) + "关键字",
+			[]string{"关键字"},
+			"…" + strings.Repeat("中", snippetRunes-3) + "<mark>关键字</mark>",
+		},
+		{
+			"四字节字符",
+			strings.Repeat("😀", 80) + "go" + strings.Repeat("🎉", 80),
+			[]string{"go"},
+			"…" + strings.Repeat("😀", quarter) + "<mark>go</mark>" + strings.Repeat("🎉", snippetRunes-quarter-2) + "…",
+		},
+	}
+	for _, tt := range tests {
+		got := makeSnippet(tt.text, tt.terms)
+		if got != tt.want {
+			t.Errorf("%s: makeSnippet() = %q，应为 %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: 摘要不是有效的UTF-8: %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// 会话有效期，每次使用时顺延
+const SessionTTL = 30 * 24 * time.Hour
+
+// Session 表示服务端签发的登录会话
+type Session struct {
+	Token     string    `json:"-"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// 生成随机会话令牌
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateSession 为用户创建新会话
+func CreateSession(userID int64) (*Session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{
+		Token:     token,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTTL),
+	}
+
+	if err := store.CreateSession(session); err != nil {
+		log.Printf("创建会话失败: %v", err)
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetUserBySession 根据会话令牌获取用户，并顺延会话有效期
+func GetUserBySession(token string) (*User, error) {
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	session, err := store.GetSession(token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		store.DeleteSession(token)
+		return nil, ErrSessionNotFound
+	}
+
+	user, err := store.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	if err := store.ExtendSession(token, now.UTC().Add(SessionTTL)); err != nil {
+		log.Printf("顺延会话有效期失败: %v", err)
+	}
+
+	return user, nil
+}
+
+// DeleteSession 删除会话
+func DeleteSession(token string) error {
+	return store.DeleteSession(token)
+}
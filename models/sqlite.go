@@ -0,0 +1,1100 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrSQLiteUnavailable 表示无法打开SQLite数据库，例如编译时未启用CGO
+var ErrSQLiteUnavailable = errors.New("SQLite数据库不可用")
+
+// SQLiteStore 是基于SQLite文件数据库的存储
+type SQLiteStore struct {
+	db  *sql.DB
+	fts bool // 是否使用FTS5全文索引，未启用FTS5时使用LIKE逐条匹配
+}
+
+// openSQLite 打开SQLite数据库并确认连接可用
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSQLiteUnavailable, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%w: %v", ErrSQLiteUnavailable, err)
+	}
+	return db, nil
+}
+
+// NewSQLiteStore 打开SQLite数据库并执行尚未执行的迁移
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrateUp(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLiteStore{db: db}
+	if err := s.initSearchIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// 确保默认房间存在
+	_, err = db.Exec(`INSERT OR IGNORE INTO rooms (id, name, created_by) VALUES (?, ?, 0)`, DefaultRoomID, DefaultRoomName)
+	if err != nil {
+		log.Printf("创建默认房间失败: %v", err)
+	}
+
+	return s, nil
+}
+
+// isUniqueViolation 判断错误是否由唯一约束冲突引起
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// 把查询单行时的sql.ErrNoRows转换为models中的错误
+func notFound(err, notFoundErr error) error {
+	if err == sql.ErrNoRows {
+		return notFoundErr
+	}
+	return err
+}
+
+// 用户表查询的公共列
+const userColumns = `id, ip, username, login_name, password_hash, last_online, is_admin, muted_until, presence, status_text`
+
+// 扫描用户行
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.IP, &user.Username, &user.LoginName, &user.PasswordHash, &user.LastOnline,
+		&user.IsAdmin, &user.MutedUntil, &user.Presence, &user.StatusText)
+	if err != nil {
+		return nil, err
+	}
+
+	user.UsernameStr = user.Username.String
+	user.LoginNameStr = user.LoginName.String
+
+	return &user, nil
+}
+
+// 扫描多行用户
+func scanUsers(rows *sql.Rows) ([]*User, error) {
+	defer rows.Close()
+
+	users := make([]*User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Printf("扫描用户行失败: %v", err)
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// CreateUser 创建新用户
+func (s *SQLiteStore) CreateUser(ip, username string) (*User, error) {
+	var name sql.NullString
+	if username != "" {
+		name = sql.NullString{String: username, Valid: true}
+	}
+
+	query := `INSERT INTO users (ip, username, last_online) VALUES (?, ?, CURRENT_TIMESTAMP)`
+	result, err := s.db.Exec(query, ip, name)
+	if err != nil {
+		log.Printf("创建用户失败: %v", err)
+		return nil, err
+	}
+
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUserByID(userID)
+}
+
+// GetUserByID 根据ID获取用户
+func (s *SQLiteStore) GetUserByID(userID int64) (*User, error) {
+	user, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userID))
+	if err != nil {
+		return nil, notFound(err, ErrNoRows)
+	}
+	return user, nil
+}
+
+// GetUserByLoginName 根据登录名获取用户
+func (s *SQLiteStore) GetUserByLoginName(loginName string) (*User, error) {
+	user, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE login_name = ?`, loginName))
+	if err != nil {
+		return nil, notFound(err, ErrNoRows)
+	}
+	return user, nil
+}
+
+// GetUsersByNames 获取昵称或登录名在names中的用户
+func (s *SQLiteStore) GetUsersByNames(names []string) ([]*User, error) {
+	if len(names) == 0 {
+		return []*User{}, nil
+	}
+
+	args := make([]interface{}, 0, len(names)*2)
+	for _, name := range names {
+		args = append(args, name)
+	}
+	args = append(args, args...)
+
+	in := placeholders(len(names))
+	rows, err := s.db.Query(`SELECT `+userColumns+` FROM users
+		WHERE username IN (`+in+`) OR login_name IN (`+in+`)
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+// SetCredentials 设置用户的登录名和密码哈希，唯一索引保证并发注册时登录名不重复
+func (s *SQLiteStore) SetCredentials(userID int64, loginName, passwordHash string) error {
+	result, err := s.db.Exec(`UPDATE users SET login_name = ?, password_hash = ? WHERE id = ?`, loginName, passwordHash, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrLoginNameTaken
+		}
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// UpdateUserIP 记录用户最近一次连接使用的IP
+func (s *SQLiteStore) UpdateUserIP(userID int64, ip string) error {
+	result, err := s.db.Exec(`UPDATE users SET ip = ? WHERE id = ?`, ip, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// UpdateUsername 更新用户名
+func (s *SQLiteStore) UpdateUsername(userID int64, username string) error {
+	result, err := s.db.Exec(`UPDATE users SET username = ? WHERE id = ?`, username, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// SetPresence 设置用户的在线状态和状态文字
+func (s *SQLiteStore) SetPresence(userID int64, presence, statusText string) error {
+	result, err := s.db.Exec(`UPDATE users SET presence = ?, status_text = ? WHERE id = ?`, presence, statusText, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// UpdateLastOnline 更新用户的最后在线时间
+func (s *SQLiteStore) UpdateLastOnline(userID int64) error {
+	result, err := s.db.Exec(`UPDATE users SET last_online = CURRENT_TIMESTAMP WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// GetUsersOnlineSince 获取指定时间之后活跃过的用户
+func (s *SQLiteStore) GetUsersOnlineSince(since time.Time) ([]*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE datetime(last_online) > datetime(?)
+		ORDER BY last_online DESC`
+
+	rows, err := s.db.Query(query, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+// DeleteInactiveGuests 删除指定时间之前不再活跃、没有会话和消息的访客用户
+func (s *SQLiteStore) DeleteInactiveGuests(before time.Time) (int64, error) {
+	query := `DELETE FROM users
+		WHERE login_name IS NULL
+		AND datetime(last_online) < datetime(?)
+		AND id NOT IN (SELECT user_id FROM sessions)
+		AND id NOT IN (SELECT user_id FROM messages)
+		AND id NOT IN (SELECT user_id FROM message_reactions)
+		AND id NOT IN (SELECT user_id FROM bans)`
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// 已读记录和提及记录随用户一起删除
+	for _, table := range []string{"read_state", "message_mentions"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table + ` WHERE user_id NOT IN (SELECT id FROM users)`); err != nil {
+			return 0, err
+		}
+	}
+
+	return deleted, tx.Commit()
+}
+
+// CountUsers 获取用户数量
+func (s *SQLiteStore) CountUsers() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// CreateSession 保存会话
+func (s *SQLiteStore) CreateSession(session *Session) error {
+	query := `INSERT INTO sessions (token, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`
+	_, err := s.db.Exec(query, session.Token, session.UserID, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+// GetSession 根据令牌获取会话
+func (s *SQLiteStore) GetSession(token string) (*Session, error) {
+	session := Session{Token: token}
+	query := `SELECT user_id, created_at, expires_at FROM sessions WHERE token = ?`
+	err := s.db.QueryRow(query, token).Scan(&session.UserID, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, notFound(err, ErrSessionNotFound)
+	}
+	return &session, nil
+}
+
+// ExtendSession 修改会话过期时间
+func (s *SQLiteStore) ExtendSession(token string, expiresAt time.Time) error {
+	_, err := s.db.Exec(`UPDATE sessions SET expires_at = ? WHERE token = ?`, expiresAt.UTC(), token)
+	return err
+}
+
+// DeleteSession 删除会话
+func (s *SQLiteStore) DeleteSession(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+	return err
+}
+
+// DeleteExpiredSessions 删除已过期的会话
+func (s *SQLiteStore) DeleteExpiredSessions(now time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE datetime(expires_at) < datetime(?)`, now.UTC())
+	return err
+}
+
+// CreateRoom 创建新房间，房间名称不可重复
+func (s *SQLiteStore) CreateRoom(name string, userID int64) (*Room, error) {
+	result, err := s.db.Exec(`INSERT INTO rooms (name, created_by, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, name, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrRoomExists
+		}
+		log.Printf("创建房间失败: %v", err)
+		return nil, err
+	}
+
+	roomID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetRoomByID(roomID)
+}
+
+// GetRooms 获取所有房间
+func (s *SQLiteStore) GetRooms() ([]*Room, error) {
+	rows, err := s.db.Query(`SELECT id, name, created_by, created_at FROM rooms ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]*Room, 0)
+	for rows.Next() {
+		var room Room
+		err := rows.Scan(&room.ID, &room.Name, &room.CreatedBy, &room.CreatedAt)
+		if err != nil {
+			log.Printf("扫描房间行失败: %v", err)
+			continue
+		}
+		rooms = append(rooms, &room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetRoomByID 根据ID获取房间
+func (s *SQLiteStore) GetRoomByID(roomID int64) (*Room, error) {
+	var room Room
+	query := `SELECT id, name, created_by, created_at FROM rooms WHERE id = ?`
+	err := s.db.QueryRow(query, roomID).Scan(&room.ID, &room.Name, &room.CreatedBy, &room.CreatedAt)
+	if err != nil {
+		return nil, notFound(err, ErrRoomNotFound)
+	}
+	return &room, nil
+}
+
+// CreateFile 记录上传的文件信息
+func (s *SQLiteStore) CreateFile(file *File) (*File, error) {
+	query := `INSERT INTO files (hash, name, mime_type, size, uploader_id, created_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	result, err := s.db.Exec(query, file.Hash, file.Name, file.MimeType, file.Size, file.UploaderID)
+	if err != nil {
+		log.Printf("保存文件信息失败: %v", err)
+		return nil, err
+	}
+
+	fileID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetFileByID(fileID)
+}
+
+// CountFileMessages 统计引用文件的消息数，visible为其中未撤回的消息数
+func (s *SQLiteStore) CountFileMessages(fileID int64) (visible, total int, err error) {
+	err = s.db.QueryRow(`SELECT COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0), COUNT(*)
+		FROM messages WHERE file_id = ?`, MessageStatusNormal, fileID).Scan(&visible, &total)
+	return visible, total, err
+}
+
+// GetFileByID 根据ID获取文件信息
+func (s *SQLiteStore) GetFileByID(fileID int64) (*File, error) {
+	var file File
+	query := `SELECT id, hash, name, mime_type, size, uploader_id, created_at FROM files WHERE id = ?`
+	err := s.db.QueryRow(query, fileID).Scan(
+		&file.ID,
+		&file.Hash,
+		&file.Name,
+		&file.MimeType,
+		&file.Size,
+		&file.UploaderID,
+		&file.CreatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err, ErrFileNotFound)
+	}
+	return &file, nil
+}
+
+// 消息查询的公共部分，附带发送者的用户名
+const messageSelect = `
+	SELECT m.id, m.user_id, u.username, m.content, m.type, m.status, m.file_name, m.file_size, m.room_id, m.recipient_id, m.file_id, m.reply_to, m.created_at, m.edited_at, m.recalled_at
+	FROM messages m
+	LEFT JOIN users u ON m.user_id = u.id`
+
+// 扫描消息行
+func scanMessage(row interface{ Scan(...interface{}) error }) (*Message, error) {
+	var msg Message
+	err := row.Scan(
+		&msg.ID,
+		&msg.UserID,
+		&msg.Username,
+		&msg.Content,
+		&msg.Type,
+		&msg.Status,
+		&msg.FileName,
+		&msg.FileSize,
+		&msg.RoomID,
+		&msg.RecipientID,
+		&msg.FileID,
+		&msg.ReplyTo,
+		&msg.CreatedAt,
+		&msg.EditedAt,
+		&msg.RecalledAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// 设置用户友好字段
+	msg.UsernameStr = msg.Username.String
+	msg.FileNameStr = msg.FileName.String
+	msg.FileSizeVal = msg.FileSize.Int64
+
+	return &msg, nil
+}
+
+// 扫描多行消息
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
+	defer rows.Close()
+
+	messages := make([]*Message, 0)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			log.Printf("扫描消息行失败: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// CreateMessage 保存消息
+func (s *SQLiteStore) CreateMessage(msg *Message) (*Message, error) {
+	query := `INSERT INTO messages (user_id, room_id, recipient_id, content, type, file_name, file_size, file_id, reply_to, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	result, err := s.db.Exec(query, msg.UserID, msg.RoomID, msg.RecipientID, msg.Content, msg.Type, msg.FileName, msg.FileSize, msg.FileID, msg.ReplyTo)
+	if err != nil {
+		return nil, err
+	}
+
+	msgID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetMessageByID(msgID)
+}
+
+// GetMessageByID 根据ID获取消息
+func (s *SQLiteStore) GetMessageByID(messageID int64) (*Message, error) {
+	msg, err := scanMessage(s.db.QueryRow(messageSelect+` WHERE m.id = ?`, messageID))
+	if err != nil {
+		return nil, notFound(err, ErrMessageNotFound)
+	}
+	return msg, nil
+}
+
+// GetMessages 按分页条件获取指定房间的消息，按时间从早到晚排列
+func (s *SQLiteStore) GetMessages(roomID int64, page Page) ([]*Message, error) {
+	return s.pageMessages(`m.room_id = ? AND m.recipient_id = 0`, []interface{}{roomID}, page)
+}
+
+// 按分页条件获取满足where条件的消息，按时间从早到晚排列
+func (s *SQLiteStore) pageMessages(where string, args []interface{}, page Page) ([]*Message, error) {
+	var query string
+	switch {
+	case page.After != 0:
+		// 紧接在After之后的消息
+		query = messageSelect + `
+		WHERE ` + where + ` AND m.id > ?
+		ORDER BY m.id ASC
+		LIMIT ?`
+		args = append(args, page.After, page.Limit)
+	case page.Before != 0:
+		// 紧接在Before之前的消息
+		query = `SELECT * FROM (` + messageSelect + `
+		WHERE ` + where + ` AND m.id < ?
+		ORDER BY m.id DESC
+		LIMIT ?
+	) ORDER BY id ASC`
+		args = append(args, page.Before, page.Limit)
+	default:
+		// 最新的消息
+		query = `SELECT * FROM (` + messageSelect + `
+		WHERE ` + where + `
+		ORDER BY m.id DESC
+		LIMIT ?
+	) ORDER BY id ASC`
+		args = append(args, page.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// GetMentions 按分页条件获取提到指定用户的消息，按时间从早到晚排列
+func (s *SQLiteStore) GetMentions(userID int64, page Page) ([]*Message, error) {
+	return s.pageMessages(`m.id IN (SELECT message_id FROM message_mentions WHERE user_id = ?)`, []interface{}{userID}, page)
+}
+
+// AddMentions 记录消息提到的用户
+func (s *SQLiteStore) AddMentions(messageID int64, userIDs []int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userID := range userIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO message_mentions (message_id, user_id) VALUES (?, ?)`, messageID, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetConversation 获取两个用户之间最近的私信，按时间从早到晚排列
+func (s *SQLiteStore) GetConversation(userID, peerID int64, limit int) ([]*Message, error) {
+	query := `SELECT * FROM (` + messageSelect + `
+		WHERE (m.user_id = ? AND m.recipient_id = ?) OR (m.user_id = ? AND m.recipient_id = ?)
+		ORDER BY m.id DESC
+		LIMIT ?
+	) ORDER BY id ASC`
+
+	rows, err := s.db.Query(query, userID, peerID, peerID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// 多个房间的消息和用户参与的私信，返回查询条件和参数
+func roomsAndDirectScope(userID int64, roomIDs []int64) (string, []interface{}) {
+	rooms := `0`
+	if len(roomIDs) > 0 {
+		rooms = placeholders(len(roomIDs))
+	}
+	where := `((m.recipient_id = 0 AND m.room_id IN (` + rooms + `)) OR (m.recipient_id != 0 AND (m.user_id = ? OR m.recipient_id = ?)))`
+	return where, append(int64Args(roomIDs), userID, userID)
+}
+
+// GetMessagesAfter 获取多个房间的消息和用户私信中ID大于afterID的最早limit条，按时间从早到晚排列
+func (s *SQLiteStore) GetMessagesAfter(userID int64, roomIDs []int64, afterID int64, limit int) ([]*Message, error) {
+	where, args := roomsAndDirectScope(userID, roomIDs)
+	return s.pageMessages(where, args, Page{After: afterID, Limit: limit})
+}
+
+// GetChangedMessages 获取多个房间的消息和用户私信中ID不大于maxID、在since及之后被编辑或撤回的消息，按时间从早到晚排列
+func (s *SQLiteStore) GetChangedMessages(userID int64, roomIDs []int64, maxID int64, since time.Time, limit int) ([]*Message, error) {
+	where, args := roomsAndDirectScope(userID, roomIDs)
+	query := messageSelect + `
+		WHERE ` + where + ` AND m.id <= ?
+		AND (datetime(m.edited_at) >= datetime(?) OR datetime(m.recalled_at) >= datetime(?))
+		ORDER BY m.id ASC
+		LIMIT ?`
+
+	since = since.UTC()
+	rows, err := s.db.Query(query, append(args, maxID, since, since, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// SearchMessages 搜索房间消息，使用全文索引时按相关度排列，否则按时间从新到旧排列
+func (s *SQLiteStore) SearchMessages(query SearchQuery) ([]*Message, error) {
+	conditions := []string{
+		`m.recipient_id = 0`,
+		fmt.Sprintf(`m.status = %d`, MessageStatusNormal),
+		fmt.Sprintf(`m.type != %d`, MessageTypeSystem),
+	}
+	var args []interface{}
+
+	from := messageSelect
+	order := `m.id DESC`
+	if s.fts {
+		// trigram分词器只能用MATCH匹配不少于三个字符的关键字，较短的关键字用LIKE匹配
+		from += ` JOIN messages_fts ON messages_fts.rowid = m.id`
+		var phrases []string
+		for _, term := range query.Terms {
+			if len([]rune(term)) >= gramSize {
+				phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+			} else {
+				conditions = append(conditions, `messages_fts.body LIKE ? ESCAPE '\'`)
+				args = append(args, likePattern(term))
+			}
+		}
+		if len(phrases) > 0 {
+			conditions = append(conditions, `messages_fts MATCH ?`)
+			args = append(args, strings.Join(phrases, " "))
+			order = `bm25(messages_fts), m.id DESC`
+		}
+	} else {
+		for _, term := range query.Terms {
+			conditions = append(conditions, searchBodyExpr("m")+` LIKE ? ESCAPE '\'`)
+			args = append(args, likePattern(term))
+		}
+	}
+
+	if query.SenderID != 0 {
+		conditions = append(conditions, `m.user_id = ?`)
+		args = append(args, query.SenderID)
+	}
+	if query.RoomID != 0 {
+		conditions = append(conditions, `m.room_id = ?`)
+		args = append(args, query.RoomID)
+	}
+	if len(query.Types) > 0 {
+		conditions = append(conditions, `m.type IN (`+placeholders(len(query.Types))+`)`)
+		for _, t := range query.Types {
+			args = append(args, t)
+		}
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, `datetime(m.created_at) >= datetime(?)`)
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, `datetime(m.created_at) < datetime(?)`)
+		args = append(args, query.Until.UTC())
+	}
+
+	sqlQuery := from + `
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + order + `
+		LIMIT ? OFFSET ?`
+	args = append(args, query.Limit, query.Offset)
+
+	rows, err := s.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// 转义LIKE中的通配符，生成包含关键字的匹配模式
+func likePattern(term string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(term) + "%"
+}
+
+// GetReplies 获取回复指定消息的最近的消息，按时间从早到晚排列
+func (s *SQLiteStore) GetReplies(parentID int64, limit int) ([]*Message, error) {
+	query := `SELECT * FROM (` + messageSelect + `
+		WHERE m.reply_to = ?
+		ORDER BY m.id DESC
+		LIMIT ?
+	) ORDER BY id ASC`
+
+	rows, err := s.db.Query(query, parentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// CountReplies 获取多条消息各自的回复数量，没有回复的消息不在结果中
+func (s *SQLiteStore) CountReplies(messageIDs []int64) (map[int64]int, error) {
+	counts := make(map[int64]int)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	query := `SELECT reply_to, COUNT(*) FROM messages
+		WHERE reply_to IN (` + placeholders(len(messageIDs)) + `)
+		GROUP BY reply_to`
+	rows, err := s.db.Query(query, int64Args(messageIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+	return counts, rows.Err()
+}
+
+// SetMessageStatus 修改消息状态，改为已撤回时记录撤回时间
+func (s *SQLiteStore) SetMessageStatus(messageID int64, status int) error {
+	result, err := s.db.Exec(`UPDATE messages SET status = ?,
+		recalled_at = CASE WHEN ? = ? THEN CURRENT_TIMESTAMP ELSE recalled_at END
+		WHERE id = ?`, status, status, MessageStatusRecalled, messageID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrMessageNotFound)
+}
+
+// RemoveMessage 把消息标记为已撤回，并清除内容、引用的文件和编辑历史
+func (s *SQLiteStore) RemoveMessage(messageID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE messages SET status = ?, content = '', file_id = 0, file_name = NULL, file_size = NULL,
+		recalled_at = CURRENT_TIMESTAMP WHERE id = ?`, MessageStatusRecalled, messageID)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result, ErrMessageNotFound); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EditMessage 保存消息编辑前的内容，然后修改消息内容和编辑时间
+func (s *SQLiteStore) EditMessage(messageID int64, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO message_edits (message_id, content) SELECT id, content FROM messages WHERE id = ?`, messageID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE messages SET content = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?`, content, messageID)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result, ErrMessageNotFound); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetMessageEdits 获取消息的历史版本，按编辑时间从早到晚排列
+func (s *SQLiteStore) GetMessageEdits(messageID int64) ([]*MessageEdit, error) {
+	rows, err := s.db.Query(`SELECT id, message_id, content, edited_at FROM message_edits WHERE message_id = ? ORDER BY id ASC`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := make([]*MessageEdit, 0)
+	for rows.Next() {
+		var edit MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Content, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, &edit)
+	}
+	return edits, rows.Err()
+}
+
+// CountMessages 获取消息数量
+func (s *SQLiteStore) CountMessages() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&count)
+	return count, err
+}
+
+// MarkRead 把已读位置推进到state.LastReadID，已读位置不会后退，返回是否有变化
+func (s *SQLiteStore) MarkRead(state *ReadState) (bool, error) {
+	result, err := s.db.Exec(`INSERT INTO read_state (user_id, room_id, peer_id, last_read_id, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, room_id, peer_id) DO UPDATE
+		SET last_read_id = excluded.last_read_id, updated_at = excluded.updated_at
+		WHERE excluded.last_read_id > read_state.last_read_id`,
+		state.UserID, state.RoomID, state.PeerID, state.LastReadID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// 已读记录查询的公共列
+const readStateColumns = `user_id, room_id, peer_id, last_read_id, updated_at`
+
+// 扫描多行已读记录
+func scanReadStates(rows *sql.Rows) ([]*ReadState, error) {
+	defer rows.Close()
+
+	states := make([]*ReadState, 0)
+	for rows.Next() {
+		var state ReadState
+		if err := rows.Scan(&state.UserID, &state.RoomID, &state.PeerID, &state.LastReadID, &state.UpdatedAt); err != nil {
+			return nil, err
+		}
+		states = append(states, &state)
+	}
+	return states, rows.Err()
+}
+
+// GetRoomReadStates 获取房间中所有用户的已读位置
+func (s *SQLiteStore) GetRoomReadStates(roomID int64) ([]*ReadState, error) {
+	rows, err := s.db.Query(`SELECT `+readStateColumns+` FROM read_state
+		WHERE room_id = ? AND peer_id = 0
+		ORDER BY last_read_id DESC`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return scanReadStates(rows)
+}
+
+// GetConversationReadStates 获取私信会话双方的已读位置
+func (s *SQLiteStore) GetConversationReadStates(userID, peerID int64) ([]*ReadState, error) {
+	rows, err := s.db.Query(`SELECT `+readStateColumns+` FROM read_state
+		WHERE room_id = 0 AND ((user_id = ? AND peer_id = ?) OR (user_id = ? AND peer_id = ?))`,
+		userID, peerID, peerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanReadStates(rows)
+}
+
+// CountUnread 统计用户在各房间和各私信会话中已读位置之后的消息数量，不含自己发送的消息
+func (s *SQLiteStore) CountUnread(userID int64) (*UnreadCounts, error) {
+	counts := newUnreadCounts()
+
+	roomQuery := `SELECT m.room_id, COUNT(*) FROM messages m
+		LEFT JOIN read_state r ON r.user_id = ? AND r.room_id = m.room_id AND r.peer_id = 0
+		WHERE m.recipient_id = 0 AND m.user_id != ? AND ` + unreadExpr("m") + `
+		AND m.id > COALESCE(r.last_read_id, 0)
+		GROUP BY m.room_id`
+	if err := s.scanCounts(counts.Rooms, roomQuery, userID, userID); err != nil {
+		return nil, err
+	}
+
+	directQuery := `SELECT m.user_id, COUNT(*) FROM messages m
+		LEFT JOIN read_state r ON r.user_id = ? AND r.room_id = 0 AND r.peer_id = m.user_id
+		WHERE m.recipient_id = ? AND ` + unreadExpr("m") + `
+		AND m.id > COALESCE(r.last_read_id, 0)
+		GROUP BY m.user_id`
+	if err := s.scanCounts(counts.Direct, directQuery, userID, userID); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// 计入未读数量的消息，与countsAsUnread保持一致
+func unreadExpr(table string) string {
+	return fmt.Sprintf(`%[1]s.status = %[2]d AND %[1]s.type != %[3]d`, table, MessageStatusNormal, MessageTypeSystem)
+}
+
+// 把"ID, 数量"两列的查询结果写入counts
+func (s *SQLiteStore) scanCounts(counts map[int64]int, query string, args ...interface{}) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return err
+		}
+		counts[id] = count
+	}
+	return rows.Err()
+}
+
+// ToggleReaction 添加或取消表情回应，返回是否为添加
+func (s *SQLiteStore) ToggleReaction(messageID, userID int64, emoji string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?`, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if removed == 0 {
+		_, err = tx.Exec(`INSERT INTO message_reactions (message_id, user_id, emoji) VALUES (?, ?, ?)`, messageID, userID, emoji)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return removed == 0, tx.Commit()
+}
+
+// GetReactions 获取多条消息的表情回应汇总
+func (s *SQLiteStore) GetReactions(messageIDs []int64) (map[int64][]ReactionCount, error) {
+	if len(messageIDs) == 0 {
+		return map[int64][]ReactionCount{}, nil
+	}
+
+	query := `SELECT message_id, user_id, emoji FROM message_reactions
+		WHERE message_id IN (` + placeholders(len(messageIDs)) + `)
+		ORDER BY created_at, rowid`
+	rows, err := s.db.Query(query, int64Args(messageIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reactions []reaction
+	for rows.Next() {
+		var r reaction
+		if err := rows.Scan(&r.MessageID, &r.UserID, &r.Emoji); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return aggregateReactions(reactions), nil
+}
+
+// 生成IN查询使用的占位符，例如"?,?,?"
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// 把ID列表转换为查询参数
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// 更新语句没有影响任何行时返回notFoundErr
+func requireAffected(result sql.Result, notFoundErr error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFoundErr
+	}
+	return nil
+}
+
+// SetAdmin 设置用户是否为管理员
+func (s *SQLiteStore) SetAdmin(userID int64, admin bool) error {
+	result, err := s.db.Exec(`UPDATE users SET is_admin = ? WHERE id = ?`, admin, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// SetMutedUntil 设置用户的禁言截止时间，until为空时解除禁言
+func (s *SQLiteStore) SetMutedUntil(userID int64, until *time.Time) error {
+	var value interface{}
+	if until != nil {
+		value = until.UTC()
+	}
+	result, err := s.db.Exec(`UPDATE users SET muted_until = ? WHERE id = ?`, value, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrNoRows)
+}
+
+// 封禁记录查询的公共列
+const banColumns = `id, user_id, ip, reason, created_by, created_at, expires_at`
+
+// 扫描封禁记录行
+func scanBan(row interface{ Scan(...interface{}) error }) (*Ban, error) {
+	var ban Ban
+	if err := row.Scan(&ban.ID, &ban.UserID, &ban.IP, &ban.Reason, &ban.CreatedBy, &ban.CreatedAt, &ban.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// CreateBan 保存封禁记录
+func (s *SQLiteStore) CreateBan(ban *Ban) (*Ban, error) {
+	var expiresAt interface{}
+	if ban.ExpiresAt != nil {
+		expiresAt = ban.ExpiresAt.UTC()
+	}
+	result, err := s.db.Exec(`INSERT INTO bans (user_id, ip, reason, created_by, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		ban.UserID, ban.IP, ban.Reason, ban.CreatedBy, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBanByID(id)
+}
+
+// GetBanByID 根据ID获取封禁记录
+func (s *SQLiteStore) GetBanByID(banID int64) (*Ban, error) {
+	ban, err := scanBan(s.db.QueryRow(`SELECT `+banColumns+` FROM bans WHERE id = ?`, banID))
+	if err != nil {
+		return nil, notFound(err, ErrBanNotFound)
+	}
+	return ban, nil
+}
+
+// GetActiveBans 获取在指定时间仍然生效的封禁记录，按创建时间从新到旧排列
+func (s *SQLiteStore) GetActiveBans(now time.Time) ([]*Ban, error) {
+	rows, err := s.db.Query(`SELECT `+banColumns+` FROM bans
+		WHERE expires_at IS NULL OR datetime(expires_at) > datetime(?)
+		ORDER BY id DESC`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make([]*Ban, 0)
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+// DeleteBan 删除封禁记录
+func (s *SQLiteStore) DeleteBan(banID int64) error {
+	result, err := s.db.Exec(`DELETE FROM bans WHERE id = ?`, banID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, ErrBanNotFound)
+}
+
+// AddAuditEntry 保存管理操作记录
+func (s *SQLiteStore) AddAuditEntry(entry *AuditEntry) error {
+	_, err := s.db.Exec(`INSERT INTO moderation_log (admin_id, action, target_user_id, message_id, detail) VALUES (?, ?, ?, ?, ?)`,
+		entry.AdminID, entry.Action, entry.TargetUserID, entry.MessageID, entry.Detail)
+	return err
+}
+
+// GetAuditLog 获取最近的管理操作记录，按时间从新到旧排列
+func (s *SQLiteStore) GetAuditLog(limit int) ([]*AuditEntry, error) {
+	rows, err := s.db.Query(`SELECT id, admin_id, action, target_user_id, message_id, detail, created_at
+		FROM moderation_log ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.AdminID, &entry.Action, &entry.TargetUserID, &entry.MessageID, &entry.Detail, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
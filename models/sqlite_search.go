@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"log"
+)
+
+// 全文索引的触发器，消息新增、编辑、撤回时同步更新索引
+var searchTriggers = []string{"messages_fts_insert", "messages_fts_update", "messages_fts_delete"}
+
+// 被索引的文本，与searchBody保持一致
+func searchBodyExpr(table string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s.type IN (%[2]d, %[3]d) THEN COALESCE(%[1]s.file_name, '') ELSE %[1]s.content END`,
+		table, MessageTypeImage, MessageTypeFile)
+}
+
+// 进入索引的消息，与searchable保持一致
+func searchableExpr(table string) string {
+	return fmt.Sprintf(`%[1]s.status = %[2]d AND %[1]s.type != %[3]d`, table, MessageStatusNormal, MessageTypeSystem)
+}
+
+// initSearchIndex 准备FTS5全文索引
+//
+// 全文索引是可以从messages表重建的派生数据，因此不放在迁移脚本中：
+// 未启用FTS5的程序删除触发器并改用LIKE搜索，避免写入消息时因缺少FTS5模块而失败；
+// 之后启用FTS5的程序发现触发器缺失时重建整个索引
+func (s *SQLiteStore) initSearchIndex() error {
+	var enabled bool
+	if err := s.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return err
+	}
+
+	if !enabled {
+		for _, name := range searchTriggers {
+			if _, err := s.db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return err
+			}
+		}
+		log.Println("SQLite未启用FTS5，消息搜索使用LIKE匹配")
+		return nil
+	}
+
+	var triggers int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'`).Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	s.fts = true
+	if triggers == len(searchTriggers) {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(body, tokenize = 'trigram')`,
+		`DELETE FROM messages_fts`,
+		`INSERT INTO messages_fts (rowid, body)
+			SELECT id, ` + searchBodyExpr("messages") + ` FROM messages WHERE ` + searchableExpr("messages"),
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages
+			WHEN ` + searchableExpr("new") + ` BEGIN
+			INSERT INTO messages_fts (rowid, body) VALUES (new.id, ` + searchBodyExpr("new") + `);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content, status, type, file_name ON messages BEGIN
+			DELETE FROM messages_fts WHERE rowid = old.id;
+			INSERT INTO messages_fts (rowid, body) SELECT new.id, ` + searchBodyExpr("new") + ` WHERE ` + searchableExpr("new") + `;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			DELETE FROM messages_fts WHERE rowid = old.id;
+		END`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("创建全文索引失败: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("已重建消息全文索引")
+	return nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// Store 是数据存储接口，SQLite和内存两种实现必须保持相同的行为
+//
+// 存储只负责读写数据，撤回时限、密码校验、会话过期等业务规则
+// 由models包中的函数统一实现，避免两种存储各自实现后逐渐不一致
+type Store interface {
+	// 用户
+	CreateUser(ip, username string) (*User, error)
+	GetUserByID(userID int64) (*User, error)
+	GetUserByLoginName(loginName string) (*User, error)
+	GetUsersByNames(names []string) ([]*User, error)
+	SetCredentials(userID int64, loginName, passwordHash string) error
+	UpdateUserIP(userID int64, ip string) error
+	UpdateUsername(userID int64, username string) error
+	UpdateLastOnline(userID int64) error
+	SetPresence(userID int64, presence, statusText string) error
+	SetAdmin(userID int64, admin bool) error
+	SetMutedUntil(userID int64, until *time.Time) error
+	GetUsersOnlineSince(since time.Time) ([]*User, error)
+	DeleteInactiveGuests(before time.Time) (int64, error)
+	CountUsers() (int, error)
+
+	// 会话
+	CreateSession(session *Session) error
+	GetSession(token string) (*Session, error)
+	ExtendSession(token string, expiresAt time.Time) error
+	DeleteSession(token string) error
+	DeleteExpiredSessions(now time.Time) error
+
+	// 房间
+	CreateRoom(name string, userID int64) (*Room, error)
+	GetRooms() ([]*Room, error)
+	GetRoomByID(roomID int64) (*Room, error)
+
+	// 文件
+	CreateFile(file *File) (*File, error)
+	GetFileByID(fileID int64) (*File, error)
+	CountFileMessages(fileID int64) (visible, total int, err error)
+
+	// 消息
+	CreateMessage(msg *Message) (*Message, error)
+	GetMessageByID(messageID int64) (*Message, error)
+	GetMessages(roomID int64, page Page) ([]*Message, error)
+	GetConversation(userID, peerID int64, limit int) ([]*Message, error)
+	GetMessagesAfter(userID int64, roomIDs []int64, afterID int64, limit int) ([]*Message, error)
+	GetChangedMessages(userID int64, roomIDs []int64, maxID int64, since time.Time, limit int) ([]*Message, error)
+	SearchMessages(query SearchQuery) ([]*Message, error)
+	GetReplies(parentID int64, limit int) ([]*Message, error)
+	GetMentions(userID int64, page Page) ([]*Message, error)
+	AddMentions(messageID int64, userIDs []int64) error
+	CountReplies(messageIDs []int64) (map[int64]int, error)
+	SetMessageStatus(messageID int64, status int) error
+	RemoveMessage(messageID int64) error
+	EditMessage(messageID int64, content string) error
+	GetMessageEdits(messageID int64) ([]*MessageEdit, error)
+	CountMessages() (int, error)
+
+	// 已读状态
+	MarkRead(state *ReadState) (bool, error)
+	GetRoomReadStates(roomID int64) ([]*ReadState, error)
+	GetConversationReadStates(userID, peerID int64) ([]*ReadState, error)
+	CountUnread(userID int64) (*UnreadCounts, error)
+
+	// 封禁和管理操作记录
+	CreateBan(ban *Ban) (*Ban, error)
+	GetActiveBans(now time.Time) ([]*Ban, error)
+	GetBanByID(banID int64) (*Ban, error)
+	DeleteBan(banID int64) error
+	AddAuditEntry(entry *AuditEntry) error
+	GetAuditLog(limit int) ([]*AuditEntry, error)
+
+	// 表情回应
+	ToggleReaction(messageID, userID int64, emoji string) (bool, error)
+	GetReactions(messageIDs []int64) (map[int64][]ReactionCount, error)
+}
@@ -0,0 +1,505 @@
+package models
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mikewang/go-gin-websocket-msg/config"
+)
+
+// 两种存储运行相同的测试，保证行为一致
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store {
+		return NewMemoryStore()
+	}},
+	{"sqlite", func(t *testing.T) Store {
+		s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "chat.db"))
+		if errors.Is(err, ErrSQLiteUnavailable) {
+			t.Skipf("跳过SQLite存储: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("打开SQLite存储失败: %v", err)
+		}
+		t.Cleanup(func() { s.db.Close() })
+		return s
+	}},
+}
+
+// 对每种存储分别运行fn，运行期间models中的函数使用该存储和默认配置
+func forEachStore(t *testing.T, fn func(t *testing.T)) {
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			previous, previousSettings := store, settings
+			store, settings = backend.open(t), config.Default()
+			t.Cleanup(func() { store, settings = previous, previousSettings })
+			fn(t)
+		})
+	}
+}
+
+// 创建测试用户
+func mustCreateUser(t *testing.T, username string) *User {
+	t.Helper()
+	user, err := CreateUser("127.0.0.1", username)
+	if err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+// 在大厅发送测试消息
+func mustSend(t *testing.T, userID int64, content string) *Message {
+	t.Helper()
+	msg, err := CreateMessage(userID, DefaultRoomID, content, MessageTypeText)
+	if err != nil {
+		t.Fatalf("发送消息失败: %v", err)
+	}
+	return msg
+}
+
+// 取出消息ID，便于比较
+func messageIDs(messages []*Message) []int64 {
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		guest := mustCreateUser(t, "")
+
+		got, err := GetUserByID(alice.ID)
+		if err != nil {
+			t.Fatalf("获取用户失败: %v", err)
+		}
+		if got.UsernameStr != "alice" || got.Presence != PresenceOnline || got.IsAdmin {
+			t.Errorf("新用户 = %+v", got)
+		}
+		if _, err := GetUserByID(guest.ID + 100); !errors.Is(err, ErrNoRows) {
+			t.Errorf("获取不存在的用户返回 %v，应为 ErrNoRows", err)
+		}
+
+		if err := UpdateUsername(guest.ID, "bob"); err != nil {
+			t.Fatalf("更新用户名失败: %v", err)
+		}
+		if got, _ := GetUserByID(guest.ID); got.UsernameStr != "bob" {
+			t.Errorf("更新后的用户名 = %q，应为 bob", got.UsernameStr)
+		}
+
+		if _, err := RegisterUser(alice.ID, "alice", "secret123"); err != nil {
+			t.Fatalf("注册失败: %v", err)
+		}
+		if _, err := RegisterUser(guest.ID, "alice", "secret123"); !errors.Is(err, ErrLoginNameTaken) {
+			t.Errorf("重复的登录名返回 %v，应为 ErrLoginNameTaken", err)
+		}
+		if user, err := AuthenticateUser("alice", "secret123"); err != nil || user.ID != alice.ID {
+			t.Errorf("登录 = %v, %v", user, err)
+		}
+		if _, err := AuthenticateUser("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("密码错误时返回 %v，应为 ErrInvalidCredentials", err)
+		}
+
+		online, err := GetOnlineUsers()
+		if err != nil {
+			t.Fatalf("获取在线用户失败: %v", err)
+		}
+		if len(online) != 2 {
+			t.Errorf("在线用户数 = %d，应为 2", len(online))
+		}
+		if count, _ := store.CountUsers(); count != 2 {
+			t.Errorf("用户数 = %d，应为 2", count)
+		}
+	})
+}
+
+func TestStoreMessagePages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		user := mustCreateUser(t, "alice")
+		ids := make([]int64, 5)
+		for i := range ids {
+			ids[i] = mustSend(t, user.ID, "消息").ID
+		}
+
+		latest, cursor, err := GetMessagePage(DefaultRoomID, Page{Limit: 2})
+		if err != nil {
+			t.Fatalf("获取最新消息失败: %v", err)
+		}
+		if !equalIDs(messageIDs(latest), ids[3:]) || cursor != ids[3] {
+			t.Errorf("最新一页 = %v，游标 %d", messageIDs(latest), cursor)
+		}
+
+		older, cursor, err := GetMessagePage(DefaultRoomID, Page{Before: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("向前翻页失败: %v", err)
+		}
+		if !equalIDs(messageIDs(older), ids[1:3]) || cursor != ids[1] {
+			t.Errorf("向前翻页 = %v，游标 %d", messageIDs(older), cursor)
+		}
+
+		oldest, cursor, err := GetMessagePage(DefaultRoomID, Page{Before: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("向前翻页失败: %v", err)
+		}
+		if !equalIDs(messageIDs(oldest), ids[:1]) || cursor != 0 {
+			t.Errorf("最早一页 = %v，游标 %d", messageIDs(oldest), cursor)
+		}
+
+		newer, cursor, err := GetMessagePage(DefaultRoomID, Page{After: ids[0], Limit: 3})
+		if err != nil {
+			t.Fatalf("获取新消息失败: %v", err)
+		}
+		if !equalIDs(messageIDs(newer), ids[1:4]) || cursor != ids[3] {
+			t.Errorf("获取新消息 = %v，游标 %d", messageIDs(newer), cursor)
+		}
+
+		if _, _, err := GetMessagePage(DefaultRoomID, Page{Before: ids[2], After: ids[1]}); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("同时使用before和after返回 %v，应为 ErrInvalidPage", err)
+		}
+	})
+}
+
+func TestStoreMessages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		bob := mustCreateUser(t, "bob")
+
+		msg := mustSend(t, alice.ID, "你好")
+		got, err := GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("获取消息失败: %v", err)
+		}
+		if got.Content != "你好" || got.UsernameStr != "alice" || got.RoomID != DefaultRoomID || got.Status != MessageStatusNormal {
+			t.Errorf("保存的消息 = %+v", got)
+		}
+		if _, err := GetMessageByID(msg.ID + 100); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("获取不存在的消息返回 %v，应为 ErrMessageNotFound", err)
+		}
+
+		if _, err := CreateMessage(alice.ID, DefaultRoomID, "  ", MessageTypeText); !errors.Is(err, ErrEmptyContent) {
+			t.Errorf("空消息返回 %v，应为 ErrEmptyContent", err)
+		}
+
+		dm, err := CreateDirectMessage(alice.ID, bob.ID, "私信", 0)
+		if err != nil {
+			t.Fatalf("发送私信失败: %v", err)
+		}
+		conversation, err := GetConversation(bob.ID, alice.ID, 10)
+		if err != nil {
+			t.Fatalf("获取私信记录失败: %v", err)
+		}
+		if !equalIDs(messageIDs(conversation), []int64{dm.ID}) {
+			t.Errorf("私信记录 = %v，应为 [%d]", messageIDs(conversation), dm.ID)
+		}
+		room, err := GetMessages(DefaultRoomID, 10)
+		if err != nil {
+			t.Fatalf("获取房间消息失败: %v", err)
+		}
+		if !equalIDs(messageIDs(room), []int64{msg.ID}) {
+			t.Errorf("房间消息 = %v，私信不应出现在房间中", messageIDs(room))
+		}
+	})
+}
+
+func TestStoreRecall(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		bob := mustCreateUser(t, "bob")
+		msg := mustSend(t, alice.ID, "发错了")
+
+		if err := RecallMessage(msg.ID, bob.ID); !errors.Is(err, ErrRecallForbidden) {
+			t.Errorf("撤回他人消息返回 %v，应为 ErrRecallForbidden", err)
+		}
+		if err := RecallMessage(msg.ID+100, alice.ID); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("撤回不存在的消息返回 %v，应为 ErrMessageNotFound", err)
+		}
+
+		if err := RecallMessage(msg.ID, alice.ID); err != nil {
+			t.Fatalf("撤回消息失败: %v", err)
+		}
+		got, err := GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("获取消息失败: %v", err)
+		}
+		if got.Status != MessageStatusRecalled || got.RecalledAt == nil {
+			t.Errorf("撤回后的消息 = %+v", got)
+		}
+		if err := RecallMessage(msg.ID, alice.ID); !errors.Is(err, ErrAlreadyRecalled) {
+			t.Errorf("重复撤回返回 %v，应为 ErrAlreadyRecalled", err)
+		}
+
+		system, err := CreateMessage(alice.ID, DefaultRoomID, "alice 进入了聊天室", MessageTypeSystem)
+		if err != nil {
+			t.Fatalf("保存系统消息失败: %v", err)
+		}
+		if err := RecallMessage(system.ID, alice.ID); !errors.Is(err, ErrRecallSystem) {
+			t.Errorf("撤回系统消息返回 %v，应为 ErrRecallSystem", err)
+		}
+
+		settings.RecallWindow = time.Nanosecond
+		old := mustSend(t, alice.ID, "很久以前")
+		time.Sleep(time.Millisecond)
+		if err := RecallMessage(old.ID, alice.ID); !errors.Is(err, ErrRecallExpired) {
+			t.Errorf("超时撤回返回 %v，应为 ErrRecallExpired", err)
+		}
+	})
+}
+
+func TestStoreEdits(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		admin := mustCreateUser(t, "admin")
+
+		for _, remove := range []struct {
+			name string
+			fn   func(messageID int64) error
+		}{
+			{"撤回", func(messageID int64) error { return RecallMessage(messageID, alice.ID) }},
+			{"删除", func(messageID int64) error { _, err := DeleteMessage(admin.ID, messageID); return err }},
+		} {
+			msg := mustSend(t, alice.ID, "第一版")
+			if _, err := EditMessage(msg.ID, alice.ID, "第二版"); err != nil {
+				t.Fatalf("编辑消息失败: %v", err)
+			}
+			edits, err := GetMessageEdits(msg.ID)
+			if err != nil {
+				t.Fatalf("获取编辑历史失败: %v", err)
+			}
+			if len(edits) != 1 || edits[0].Content != "第一版" {
+				t.Errorf("编辑历史 = %+v，应只包含第一版", edits)
+			}
+
+			if err := remove.fn(msg.ID); err != nil {
+				t.Fatalf("%s消息失败: %v", remove.name, err)
+			}
+			if edits, err := GetMessageEdits(msg.ID); !errors.Is(err, ErrEditsRecalled) {
+				t.Errorf("%s后获取编辑历史返回 %v, %v，应为 ErrEditsRecalled", remove.name, edits, err)
+			}
+		}
+	})
+}
+
+func TestStoreFileDownload(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		bob := mustCreateUser(t, "bob")
+
+		canDownload := func(file *File, userID int64) bool {
+			t.Helper()
+			allowed, err := CanDownloadFile(file, userID)
+			if err != nil {
+				t.Fatalf("检查文件权限失败: %v", err)
+			}
+			return allowed
+		}
+		sendFile := func(name string) (*File, *Message) {
+			t.Helper()
+			file, err := CreateFile(name, name, "application/pdf", 10, alice.ID)
+			if err != nil {
+				t.Fatalf("保存文件失败: %v", err)
+			}
+			msg, err := CreateFileMessage(alice.ID, DefaultRoomID, MessageTypeFile, file, 0)
+			if err != nil {
+				t.Fatalf("发送文件消息失败: %v", err)
+			}
+			return file, msg
+		}
+
+		unsent, err := CreateFile("unsent", "unsent.pdf", "application/pdf", 10, alice.ID)
+		if err != nil {
+			t.Fatalf("保存文件失败: %v", err)
+		}
+		if !canDownload(unsent, alice.ID) || canDownload(unsent, bob.ID) {
+			t.Error("未发送的文件应只有上传者可以下载")
+		}
+
+		shared, _ := sendFile("shared.pdf")
+		if !canDownload(shared, bob.ID) {
+			t.Error("已发送的文件应所有人可以下载")
+		}
+
+		recalled, msg := sendFile("recalled.pdf")
+		if err := RecallMessage(msg.ID, alice.ID); err != nil {
+			t.Fatalf("撤回消息失败: %v", err)
+		}
+		if canDownload(recalled, bob.ID) || canDownload(recalled, alice.ID) {
+			t.Error("只被撤回的消息引用的文件不应能下载")
+		}
+
+		deleted, msg := sendFile("deleted.pdf")
+		if _, err := DeleteMessage(bob.ID, msg.ID); err != nil {
+			t.Fatalf("删除消息失败: %v", err)
+		}
+		if canDownload(deleted, bob.ID) {
+			t.Error("被删除的消息引用的文件不应能下载")
+		}
+		got, err := GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("获取消息失败: %v", err)
+		}
+		if got.FileID != 0 || got.FileNameStr != "" || got.FileSizeVal != 0 || got.Content != "" {
+			t.Errorf("删除后的消息仍包含文件: %+v", got)
+		}
+	})
+}
+
+func TestStoreSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		bob := mustCreateUser(t, "bob")
+
+		first := mustSend(t, alice.ID, "Hello world")
+		second := mustSend(t, bob.ID, "hello there")
+		mustSend(t, alice.ID, "goodbye")
+		recalled := mustSend(t, alice.ID, "hello again")
+		if err := RecallMessage(recalled.ID, alice.ID); err != nil {
+			t.Fatalf("撤回消息失败: %v", err)
+		}
+		if _, err := CreateMessage(alice.ID, DefaultRoomID, "hello system", MessageTypeSystem); err != nil {
+			t.Fatalf("保存系统消息失败: %v", err)
+		}
+		if _, err := CreateDirectMessage(alice.ID, bob.ID, "hello private", 0); err != nil {
+			t.Fatalf("发送私信失败: %v", err)
+		}
+
+		results, next, err := SearchMessages(SearchQuery{Terms: ParseSearchTerms("HELLO")})
+		if err != nil {
+			t.Fatalf("搜索失败: %v", err)
+		}
+		found := make(map[int64]bool)
+		for _, result := range results {
+			found[result.ID] = true
+		}
+		if len(results) != 2 || !found[first.ID] || !found[second.ID] || next != 0 {
+			t.Errorf("搜索hello = %v，下一页 %d", found, next)
+		}
+
+		results, _, err = SearchMessages(SearchQuery{Terms: ParseSearchTerms("hello world")})
+		if err != nil {
+			t.Fatalf("搜索失败: %v", err)
+		}
+		if len(results) != 1 || results[0].ID != first.ID || results[0].Snippet != "<mark>Hello</mark> <mark>world</mark>" {
+			t.Errorf("搜索hello world = %+v", results)
+		}
+
+		results, _, err = SearchMessages(SearchQuery{Terms: ParseSearchTerms("hello"), SenderID: bob.ID})
+		if err != nil {
+			t.Fatalf("搜索失败: %v", err)
+		}
+		if len(results) != 1 || results[0].ID != second.ID {
+			t.Errorf("按发送者搜索 = %+v", results)
+		}
+
+		results, next, err = SearchMessages(SearchQuery{Terms: ParseSearchTerms("hello"), Limit: 1})
+		if err != nil {
+			t.Fatalf("搜索失败: %v", err)
+		}
+		if len(results) != 1 || next != 1 {
+			t.Errorf("分页搜索返回 %d 条，下一页 %d", len(results), next)
+		}
+
+		if _, _, err := SearchMessages(SearchQuery{}); !errors.Is(err, ErrEmptySearch) {
+			t.Errorf("空搜索返回 %v，应为 ErrEmptySearch", err)
+		}
+	})
+}
+
+func TestStoreStatistics(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		bob := mustCreateUser(t, "bob")
+		mustSend(t, alice.ID, "一")
+		mustSend(t, bob.ID, "二")
+		if _, err := CreateDirectMessage(alice.ID, bob.ID, "三", 0); err != nil {
+			t.Fatalf("发送私信失败: %v", err)
+		}
+
+		stats, err := GetStatistics()
+		if err != nil {
+			t.Fatalf("获取统计信息失败: %v", err)
+		}
+		if stats["user_count"] != 2 {
+			t.Errorf("user_count = %v，应为 2", stats["user_count"])
+		}
+		if stats["message_count"] != 3 {
+			t.Errorf("message_count = %v，应为 3", stats["message_count"])
+		}
+		if online, _ := stats["online_users"].([]*User); len(online) != 2 {
+			t.Errorf("online_users = %v，应有 2 个用户", stats["online_users"])
+		}
+		if recent, _ := stats["recent_messages"].([]*Message); len(recent) != 2 {
+			t.Errorf("recent_messages = %v，应有 2 条大厅消息", stats["recent_messages"])
+		}
+	})
+}
+
+func TestStoreMissedMessages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		alice := mustCreateUser(t, "alice")
+		bob := mustCreateUser(t, "bob")
+		carol := mustCreateUser(t, "carol")
+		joined, err := CreateRoom("joined", alice.ID)
+		if err != nil {
+			t.Fatalf("创建房间失败: %v", err)
+		}
+		other, err := CreateRoom("other", bob.ID)
+		if err != nil {
+			t.Fatalf("创建房间失败: %v", err)
+		}
+
+		since := mustSend(t, alice.ID, "断线前")
+		edited := mustSend(t, bob.ID, "将被编辑")
+		lobby := mustSend(t, bob.ID, "大厅")
+		room, err := CreateMessage(bob.ID, joined.ID, "已加入的房间", MessageTypeText)
+		if err != nil {
+			t.Fatalf("发送消息失败: %v", err)
+		}
+		if _, err := CreateMessage(bob.ID, other.ID, "未加入的房间", MessageTypeText); err != nil {
+			t.Fatalf("发送消息失败: %v", err)
+		}
+		dm, err := CreateDirectMessage(bob.ID, alice.ID, "私信", 0)
+		if err != nil {
+			t.Fatalf("发送私信失败: %v", err)
+		}
+		if _, err := CreateDirectMessage(bob.ID, carol.ID, "别人的私信", 0); err != nil {
+			t.Fatalf("发送私信失败: %v", err)
+		}
+
+		messages, changed, err := GetMissedMessages(alice.ID, []int64{DefaultRoomID, joined.ID}, edited.ID, 10)
+		if err != nil {
+			t.Fatalf("获取错过的消息失败: %v", err)
+		}
+		if want := []int64{lobby.ID, room.ID, dm.ID}; !equalIDs(messageIDs(messages), want) {
+			t.Errorf("错过的消息 = %v，应为 %v", messageIDs(messages), want)
+		}
+		if len(changed) != 0 {
+			t.Errorf("被修改的消息 = %v，应为空", messageIDs(changed))
+		}
+
+		if _, _, err := GetMissedMessages(alice.ID, []int64{DefaultRoomID}, since.ID, 2); !errors.Is(err, ErrReplayGap) {
+			t.Errorf("超过上限时返回 %v，应为 ErrReplayGap", err)
+		}
+		if _, _, err := GetMissedMessages(alice.ID, []int64{DefaultRoomID}, dm.ID+100, 10); !errors.Is(err, ErrReplayGap) {
+			t.Errorf("since不存在时返回 %v，应为 ErrReplayGap", err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package models
+
+import "errors"
+
+// 一个话题最多返回的回复数量
+const threadLimit = 500
+
+// 回复相关错误
+var (
+	ErrReplyRecalled   = errors.New("不能回复已撤回的消息")
+	ErrReplyOutOfScope = errors.New("只能回复同一房间或同一会话中的消息")
+)
+
+// 检查被回复的消息存在、未撤回，并且与新消息在同一房间或同一私信会话中
+func checkReplyTarget(msg *Message) error {
+	parent, err := store.GetMessageByID(msg.ReplyTo)
+	if err != nil {
+		return err
+	}
+	if parent.Status == MessageStatusRecalled {
+		return ErrReplyRecalled
+	}
+
+	if msg.RecipientID == 0 {
+		if parent.RecipientID != 0 || parent.RoomID != msg.RoomID {
+			return ErrReplyOutOfScope
+		}
+		return nil
+	}
+
+	sameConversation := (parent.UserID == msg.UserID && parent.RecipientID == msg.RecipientID) ||
+		(parent.UserID == msg.RecipientID && parent.RecipientID == msg.UserID)
+	if !sameConversation {
+		return ErrReplyOutOfScope
+	}
+	return nil
+}
+
+// GetThread 获取消息及回复它的消息，回复按时间从早到晚排列
+func GetThread(messageID int64) (*Message, []*Message, error) {
+	parent, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := decorateMessages([]*Message{parent}); err != nil {
+		return nil, nil, err
+	}
+
+	replies, err := store.GetReplies(messageID, threadLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	replies, err = decorateMessages(replies)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parent, replies, nil
+}
+
+// 为消息列表填充回复数量
+func attachReplyCounts(messages []*Message) ([]*Message, error) {
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+
+	counts, err := store.CountReplies(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range messages {
+		msg.ReplyCount = counts[msg.ID]
+	}
+	return messages, nil
+}
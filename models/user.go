@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"log"
 	"time"
+	
+	"golang.org/x/crypto/bcrypt"
 )
 
 // User 表示聊天用户
@@ -12,287 +14,139 @@ type User struct {
 	IP           string       `json:"ip"`
 	Username     sql.NullString `json:"-"`
 	UsernameStr  string       `json:"username"`
+	LoginName    sql.NullString `json:"-"`
+	LoginNameStr string       `json:"login_name"` // 登录名，为空表示访客
+	PasswordHash sql.NullString `json:"-"`
 	LastOnline   time.Time    `json:"last_online"`
+	IsAdmin      bool         `json:"is_admin"`
+	MutedUntil   *time.Time   `json:"muted_until"` // 禁言截止时间，为空表示未被禁言
+	Presence     string       `json:"presence"`    // 用户设置的在线状态
+	StatusText   string       `json:"status_text"` // 自定义的状态文字
 }
 
-// GetUserByIP 根据IP地址获取用户
-func GetUserByIP(ip string) (*User, error) {
-	if UseMemoryMode {
-		return getUserByIPMemory(ip)
-	}
-	
-	// SQLite模式
-	var user User
-	query := `SELECT id, ip, username, last_online FROM users WHERE ip = ?`
-	err := DB.QueryRow(query, ip).Scan(&user.ID, &user.IP, &user.Username, &user.LastOnline)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// 用户不存在，创建新用户
-			return CreateUser(ip, "")
-		}
-		return nil, err
-	}
-	
-	// 更新最后在线时间
-	_, err = DB.Exec(`UPDATE users SET last_online = CURRENT_TIMESTAMP WHERE id = ?`, user.ID)
-	if err != nil {
-		log.Printf("更新用户最后在线时间失败: %v", err)
-	}
-	
-	user.UsernameStr = user.Username.String
-	
-	return &user, nil
+// 访客用户在最后活跃多久之后可以被清理
+const guestInactiveTimeout = time.Minute
+
+// GetUserByID 根据ID获取用户
+func GetUserByID(userID int64) (*User, error) {
+	return store.GetUserByID(userID)
 }
 
-// 内存模式下根据IP获取用户
-func getUserByIPMemory(ip string) (*User, error) {
-	usersMutex.RLock()
-	defer usersMutex.RUnlock()
-	
-	// 查找IP匹配的用户
-	for _, user := range UsersMap {
-		if user.IP == ip {
-			// 更新最后在线时间
-			user.LastOnline = time.Now()
-			
-			return user, nil
-		}
-	}
-	
-	// 用户不存在，创建新用户
-	return CreateUser(ip, "")
+// GetUserByLoginName 根据登录名获取用户
+func GetUserByLoginName(loginName string) (*User, error) {
+	return store.GetUserByLoginName(loginName)
 }
 
-// CreateUser 创建新用户
-func CreateUser(ip, username string) (*User, error) {
-	if UseMemoryMode {
-		return createUserMemory(ip, username)
+// RegisterUser 为访客用户设置登录名和密码
+func RegisterUser(userID int64, loginName, password string) (*User, error) {
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.LoginName.Valid {
+		return nil, ErrAlreadyRegistered
 	}
 	
-	// SQLite模式
-	var user User
-	user.IP = ip
-	
-	if username != "" {
-		user.Username.Valid = true
-		user.Username.String = username
-		user.UsernameStr = username
+	if _, err := store.GetUserByLoginName(loginName); err == nil {
+		return nil, ErrLoginNameTaken
 	}
 	
-	// 插入数据库
-	query := `INSERT INTO users (ip, username, last_online) VALUES (?, ?, CURRENT_TIMESTAMP)`
-	result, err := DB.Exec(query, ip, user.Username)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("创建用户失败: %v", err)
 		return nil, err
 	}
 	
-	// 获取插入ID
-	user.ID, err = result.LastInsertId()
-	if err != nil {
+	// 存储层保证并发注册时登录名不重复
+	if err := store.SetCredentials(userID, loginName, string(hash)); err != nil {
 		return nil, err
 	}
 	
-	user.LastOnline = time.Now()
+	// 配置中的管理员注册后直接成为管理员
+	if isConfiguredAdmin(loginName) {
+		if err := store.SetAdmin(userID, true); err != nil {
+			return nil, err
+		}
+	}
 	
-	return &user, nil
+	return store.GetUserByID(userID)
 }
 
-// 内存模式下创建新用户
-func createUserMemory(ip, username string) (*User, error) {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-	
-	// 生成新ID
-	LastUserID++
-	
-	user := &User{
-		ID:         LastUserID,
-		IP:         ip,
-		LastOnline: time.Now(),
+// AuthenticateUser 校验登录名和密码
+func AuthenticateUser(loginName, password string) (*User, error) {
+	user, err := store.GetUserByLoginName(loginName)
+	if err != nil {
+		return nil, ErrInvalidCredentials
 	}
 	
-	if username != "" {
-		user.Username.Valid = true
-		user.Username.String = username
-		user.UsernameStr = username
+	if !user.PasswordHash.Valid {
+		return nil, ErrInvalidCredentials
 	}
 	
-	// 存储到内存
-	UsersMap[user.ID] = user
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash.String), []byte(password))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
 	
 	return user, nil
 }
 
-// UpdateUsername 更新用户名
-func UpdateUsername(userID int64, username string) error {
-	if UseMemoryMode {
-		return updateUsernameMemory(userID, username)
-	}
-	
-	// SQLite模式
-	if username == "" {
-		return nil // 空用户名不处理
-	}
-	
-	_, err := DB.Exec(`UPDATE users SET username = ? WHERE id = ?`, username, userID)
-	return err
+// UpdateUserIP 记录用户最近一次连接使用的IP
+func UpdateUserIP(userID int64, ip string) error {
+	return store.UpdateUserIP(userID, ip)
 }
 
-// 内存模式下更新用户名
-func updateUsernameMemory(userID int64, username string) error {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-	
+// CreateUser 创建新用户
+func CreateUser(ip, username string) (*User, error) {
+	return store.CreateUser(ip, username)
+}
+
+// UpdateUsername 更新用户名
+func UpdateUsername(userID int64, username string) error {
 	if username == "" {
 		return nil // 空用户名不处理
 	}
 	
-	user, exists := UsersMap[userID]
-	if !exists {
-		return ErrNoRows
-	}
-	
-	user.Username.Valid = true
-	user.Username.String = username
-	user.UsernameStr = username
-	
-	return nil
+	return store.UpdateUsername(userID, username)
 }
 
-// GetOnlineUsers 获取最近30秒内在线的用户
+// GetOnlineUsers 获取最近活跃时间在在线判定时间内的用户
 func GetOnlineUsers() ([]*User, error) {
-	if UseMemoryMode {
-		return getOnlineUsersMemory()
-	}
-	
-	// SQLite模式
-	query := `SELECT id, ip, username, last_online FROM users 
-		WHERE last_online > datetime('now', '-30 seconds') 
-		ORDER BY last_online DESC`
-	
-	rows, err := DB.Query(query)
+	users, err := store.GetUsersOnlineSince(time.Now().Add(-settings.OnlineWindow))
 	if err != nil {
 		log.Printf("获取在线用户失败: %v", err)
 		return nil, err
 	}
-	defer rows.Close()
-	
-	var users []*User
-	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.ID, &user.IP, &user.Username, &user.LastOnline)
-		if err != nil {
-			log.Printf("扫描用户行失败: %v", err)
-			continue
-		}
-		
-		user.UsernameStr = user.Username.String
-		users = append(users, &user)
-	}
 	
 	log.Printf("当前在线用户数: %d", len(users))
 	return users, nil
 }
 
-// 内存模式下获取在线用户
-func getOnlineUsersMemory() ([]*User, error) {
-	usersMutex.RLock()
-	defer usersMutex.RUnlock()
-	
-	var users []*User
-	threshold := time.Now().Add(-30 * time.Second)
+// CleanupInactiveUsers 清理过期会话，以及没有会话和消息的访客用户
+func CleanupInactiveUsers() error {
+	now := time.Now()
 	
-	for _, user := range UsersMap {
-		if user.LastOnline.After(threshold) {
-			users = append(users, user)
-		}
+	if err := store.DeleteExpiredSessions(now); err != nil {
+		log.Printf("清理过期会话失败: %v", err)
+		return err
 	}
 	
-	log.Printf("内存模式 - 当前在线用户数: %d", len(users))
-	return users, nil
-}
-
-// CleanupInactiveUsers 清理超过1分钟未活动的用户
-func CleanupInactiveUsers() error {
-	if UseMemoryMode {
-		return cleanupInactiveUsersMemory()
-	}
-
-	// SQLite模式
-	query := `DELETE FROM users WHERE last_online < datetime('now', '-1 minute')`
-	result, err := DB.Exec(query)
+	cleaned, err := store.DeleteInactiveGuests(now.Add(-guestInactiveTimeout))
 	if err != nil {
 		log.Printf("清理不活跃用户失败: %v", err)
 		return err
 	}
-
-	affected, _ := result.RowsAffected()
-	if affected > 0 {
-		log.Printf("已清理 %d 个不活跃用户", affected)
-	}
-	return nil
-}
-
-// 内存模式下清理不活跃用户
-func cleanupInactiveUsersMemory() error {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-
-	threshold := time.Now().Add(-1 * time.Minute)
-	initialCount := len(UsersMap)
-
-	for ip, user := range UsersMap {
-		if user.LastOnline.Before(threshold) {
-			delete(UsersMap, ip)
-		}
-	}
-
-	cleaned := initialCount - len(UsersMap)
+	
 	if cleaned > 0 {
-		log.Printf("内存模式 - 已清理 %d 个不活跃用户", cleaned)
+		log.Printf("已清理 %d 个不活跃用户", cleaned)
 	}
 	return nil
 }
 
-// 内存模式下检查用户是否有消息
-func userHasMessages(userID int64) bool {
-	messageMutex.RLock()
-	defer messageMutex.RUnlock()
-	
-	for _, msg := range MessagesMap {
-		if msg.UserID == userID {
-			return true
-		}
-	}
-	
-	return false
-}
-
 // UpdateLastOnline 更新用户的最后在线时间
 func UpdateLastOnline(userID int64) error {
-	if UseMemoryMode {
-		return updateLastOnlineMemory(userID)
-	}
-	
-	// SQLite模式
-	_, err := DB.Exec(`UPDATE users SET last_online = CURRENT_TIMESTAMP WHERE id = ?`, userID)
+	err := store.UpdateLastOnline(userID)
 	if err != nil {
 		log.Printf("更新用户最后在线时间失败: %v", err)
 	}
 	return err
 }
-
-// 内存模式下更新最后在线时间
-func updateLastOnlineMemory(userID int64) error {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-	
-	user, exists := UsersMap[userID]
-	if !exists {
-		return ErrNoRows
-	}
-	
-	user.LastOnline = time.Now()
-	return nil
-} 
\ No newline at end of file
@@ -5,6 +5,8 @@ const sendButton = document.getElementById('send-btn');
 const userIP = document.getElementById('user-ip');
 const usernameInput = document.getElementById('username-input');
 const setUsernameButton = document.getElementById('set-username-btn');
+const presenceSelect = document.getElementById('presence-select');
+const statusTextInput = document.getElementById('status-text-input');
 const imageUpload = document.getElementById('image-upload');
 const fileUpload = document.getElementById('file-upload');
 const emojiToggle = document.getElementById('emoji-toggle');
@@ -15,14 +17,71 @@ const statsContent = document.getElementById('stats-content');
 const searchInput = document.getElementById('search-input');
 const searchButton = document.getElementById('search-btn');
 const searchResults = document.getElementById('search-results');
+const searchType = document.getElementById('search-type');
+const searchCurrentRoom = document.getElementById('search-current-room');
+const searchFrom = document.getElementById('search-from');
+const searchTo = document.getElementById('search-to');
 const tabButtons = document.querySelectorAll('.tab-btn');
 const tabContents = document.querySelectorAll('.tab-content');
+const roomList = document.getElementById('room-list');
+const roomNameInput = document.getElementById('room-name-input');
+const createRoomButton = document.getElementById('create-room-btn');
+const conversationBar = document.getElementById('conversation-bar');
+const conversationTitle = document.getElementById('conversation-title');
+const closeConversationButton = document.getElementById('close-conversation-btn');
+const loginStatus = document.getElementById('login-status');
+const authForm = document.getElementById('auth-form');
+const loginNameInput = document.getElementById('login-name-input');
+const passwordInput = document.getElementById('password-input');
+const loginButton = document.getElementById('login-btn');
+const registerButton = document.getElementById('register-btn');
+const logoutButton = document.getElementById('logout-btn');
+const replyBar = document.getElementById('reply-bar');
+const replyPreview = document.getElementById('reply-preview');
+const cancelReplyButton = document.getElementById('cancel-reply-btn');
+const threadPanel = document.getElementById('thread-panel');
+const threadMessages = document.getElementById('thread-messages');
+const threadTitle = document.getElementById('thread-title');
+const closeThreadButton = document.getElementById('close-thread-btn');
+const mentionList = document.getElementById('mention-list');
+const mentionsTabButton = document.getElementById('mentions-tab-btn');
 
 // WebSocket连接
 let socket;
 let currentUserIP = '';
 let localUserID = null;
+let isAdmin = false; // 当前用户是否为管理员
 let messageMap = new Map(); // 存储消息ID和DOM元素的映射
+const DEFAULT_ROOM_ID = 1;
+let currentRoomID = DEFAULT_ROOM_ID; // 当前所在房间
+let currentPeerID = null; // 当前私信会话的对方用户ID
+let replyToID = null; // 正在回复的消息ID
+let historyCursor = null; // 继续加载更早消息的游标，为null时没有更多消息
+let loadingHistory = false; // 是否正在加载更早的消息
+let readPositions = new Map(); // 当前房间或会话中其他用户的已读位置，用户ID到{username, last_read_id}
+let lastReadAck = null; // 最近发送的已读回执，避免重复发送
+let readAckTimer = null;
+let unreadCounts = { rooms: {}, direct: {} }; // 各房间和私信会话的未读数量
+let typingTarget = null; // 正在输入时发送的输入状态的范围
+let lastTypingSent = 0; // 最近一次发送输入状态的时间
+let nextRequestID = 1;
+let pendingRequests = new Map(); // 等待服务端确认的消息，请求ID到发送的消息
+let lastMessageID = 0; // 收到的最新消息ID，重连时从这之后补发错过的消息
+let receivedMessageIDs = new Set(); // 最近收到的消息ID，用于忽略补发与实时推送重复的消息
+let typingUsers = new Map(); // 正在输入的用户ID到其输入范围的集合
+let newMentions = 0; // 打开"提到我"标签之前收到的提及数量
+
+// 每次加载的历史消息数量
+const HISTORY_PAGE_SIZE = 50;
+
+// 最多记录的已收到消息ID数量
+const RECEIVED_ID_LIMIT = 1000;
+
+// 消息显示后延迟发送已读回执的时间（毫秒），连续收到消息时合并为一次
+const READ_ACK_DELAY = 1000;
+
+// 正在输入时重复发送输入状态的间隔（毫秒），需小于服务端5秒的过期时间
+const TYPING_REFRESH = 3000;
 
 // 消息类型
 const MESSAGE_TYPES = {
@@ -34,9 +93,47 @@ const MESSAGE_TYPES = {
     USERS: 'users',
     STATS: 'stats',
     FILE: 'file',
-    RECALL: 'recall'
+    RECALL: 'recall',
+    ROOM_JOIN: 'room_join',
+    ROOM_LEAVE: 'room_leave',
+    ROOMS: 'rooms',
+    DIRECT: 'direct',
+    REACTION: 'reaction',
+    EDIT: 'edit',
+    READ: 'read',
+    TYPING: 'typing',
+    MENTION: 'mention',
+    WARNING: 'warning',
+    ERROR: 'error',
+    ACK: 'ack',
+    RESYNC: 'resync',
+    PRESENCE: 'presence',
+    PRESENCE_JOIN: 'presence_join',
+    PRESENCE_LEAVE: 'presence_leave',
+    PRESENCE_UPDATE: 'presence_update'
 };
 
+// 在线状态的显示名称
+const PRESENCE_LABELS = {
+    online: '在线',
+    away: '离开',
+    busy: '忙碌',
+    dnd: '请勿打扰'
+};
+
+// 带有消息ID的新消息类型，重连后可能被补发
+const NEW_MESSAGE_TYPES = [
+    MESSAGE_TYPES.TEXT,
+    MESSAGE_TYPES.IMAGE,
+    MESSAGE_TYPES.EMOJI,
+    MESSAGE_TYPES.SYSTEM,
+    MESSAGE_TYPES.FILE,
+    MESSAGE_TYPES.DIRECT
+];
+
+// 快捷表情回应
+const QUICK_REACTIONS = ['👍', '❤️', '😂', '🎉', '😮', '😢'];
+
 // 文件大小格式化
 function formatFileSize(bytes) {
     if (bytes < 1024) return bytes + ' B';
@@ -47,11 +144,8 @@ function formatFileSize(bytes) {
 
 // 初始化应用
 function init() {
-    // 获取用户IP
-    fetchUserIP();
-    
-    // 初始化WebSocket连接
-    initWebSocket();
+    // 先获取当前用户，确保建立连接前已持有会话Cookie
+    fetchCurrentUser().then(initWebSocket);
     
     // 初始化表情列表
     initEmojis();
@@ -59,36 +153,99 @@ function init() {
     // 初始化历史消息
     fetchMessages();
     
+    // 获取房间列表
+    fetchRooms();
+    
+    // 切回页面时确认已读
+    document.addEventListener('visibilitychange', scheduleReadAck);
+    
     // 初始化标题编辑功能
     initTitleEdit();
     
     // 绑定事件
     bindEvents();
-    
-    // 每60秒刷新一次在线用户列表
-    setInterval(fetchOnlineUsers, 60000);
 }
 
-// 获取用户IP
-function fetchUserIP() {
-    // 通过服务端获取客户端真实IP
-    fetch('/api/users/online')
+// 进入聊天室并获取当前用户，没有会话时服务端创建访客用户
+function fetchCurrentUser() {
+    return fetch('/api/enter', { method: 'POST' })
         .then(response => response.json())
-        .then(users => {
-            if (users && users.length > 0) {
-                // 假设第一个返回的是当前用户
-                currentUserIP = users[0].ip;
-                userIP.textContent = `IP: ${currentUserIP}`;
+        .then(user => {
+            localUserID = user.id;
+            isAdmin = Boolean(user.is_admin);
+            currentUserIP = user.ip;
+            userIP.textContent = `IP: ${currentUserIP}`;
+            if (user.username) {
+                usernameInput.value = user.username;
             }
+            presenceSelect.value = user.presence || 'online';
+            statusTextInput.value = user.status_text || '';
+            renderLoginStatus(user);
+            
+            // 只有管理员可以修改聊天室名称
+            document.getElementById('chat-title').contentEditable = String(isAdmin);
         })
-        .catch(error => console.error('获取IP失败:', error));
+        .catch(error => console.error('获取用户信息失败:', error));
+}
+
+// 显示登录状态
+function renderLoginStatus(user) {
+    if (user.login_name) {
+        loginStatus.textContent = `已登录: ${user.login_name}`;
+        authForm.style.display = 'none';
+        logoutButton.style.display = 'inline-block';
+    } else {
+        loginStatus.textContent = '访客';
+        authForm.style.display = 'flex';
+        logoutButton.style.display = 'none';
+    }
+}
+
+// 提交登录或注册请求，成功后重新加载页面以使用新身份连接
+function submitAuth(url) {
+    const loginName = loginNameInput.value.trim();
+    const password = passwordInput.value;
+    if (!loginName || !password) return;
+    
+    fetch(url, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ login_name: loginName, password })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.error) {
+            alert(data.error);
+            return;
+        }
+        window.location.reload();
+    })
+    .catch(error => console.error('登录失败:', error));
+}
+
+// 退出登录
+function logout() {
+    fetch('/api/logout', { method: 'POST' })
+        .then(() => window.location.reload())
+        .catch(error => console.error('退出登录失败:', error));
 }
 
 // 初始化WebSocket连接
 function initWebSocket() {
     // 构建WebSocket URL
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/ws`;
+    // 重连时回到之前所在的房间，并从收到的最新消息之后补发错过的消息
+    const params = new URLSearchParams();
+    if (lastMessageID) {
+        params.set('since', lastMessageID);
+    }
+    if (currentRoomID !== DEFAULT_ROOM_ID) {
+        params.set('rooms', currentRoomID);
+    }
+    const query = params.toString() ? `?${params}` : '';
+    const wsUrl = `${protocol}//${window.location.host}/ws${query}`;
     
     // 创建WebSocket连接
     socket = new WebSocket(wsUrl);
@@ -97,22 +254,27 @@ function initWebSocket() {
     socket.onopen = () => {
         console.log('WebSocket 连接已建立');
         
-        // 连接成功后获取在线用户和统计信息
-        fetchOnlineUsers();
+        // 连接成功后获取统计信息，在线用户列表由服务端推送
         fetchStats();
     };
     
     socket.onmessage = (event) => {
-        try {
-            const message = JSON.parse(event.data);
-            handleMessage(message);
-        } catch (error) {
-            console.error('解析消息失败:', error);
-        }
+        // 服务端会把队列中的多条消息用换行分隔合并为一帧发送
+        event.data.split('\n').forEach(line => {
+            try {
+                handleMessage(JSON.parse(line));
+            } catch (error) {
+                console.error('解析消息失败:', error);
+            }
+        });
     };
     
-    socket.onclose = () => {
+    socket.onclose = (event) => {
         console.log('WebSocket 连接已关闭');
+        if (event.code === 1009) {
+            renderSystemMessage({ content: '发送的内容过大，连接已断开，正在重新连接' }, 'warning-message');
+            scrollToBottom();
+        }
         // 尝试重新连接
         setTimeout(initWebSocket, 3000);
     };
@@ -122,8 +284,45 @@ function initWebSocket() {
     };
 }
 
+// 记录收到的消息ID，返回该消息是否已经收到过
+function rememberMessageID(messageID) {
+    if (receivedMessageIDs.has(messageID)) {
+        return true;
+    }
+    receivedMessageIDs.add(messageID);
+    if (receivedMessageIDs.size > RECEIVED_ID_LIMIT) {
+        // Set按插入顺序遍历，删除最早记录的ID
+        receivedMessageIDs.delete(receivedMessageIDs.values().next().value);
+    }
+    if (messageID > lastMessageID) {
+        lastMessageID = messageID;
+    }
+    return false;
+}
+
+// 错过的消息过多无法补发时，重新加载当前房间或私信会话
+function reloadCurrentView() {
+    messageMap.clear();
+    if (currentPeerID !== null) {
+        fetchConversation(currentPeerID);
+    } else {
+        fetchMessages();
+    }
+    fetchUnreadCounts();
+}
+
 // 处理接收到的消息
 function handleMessage(message) {
+    // 重连后补发的消息可能已经实时收到过
+    if (message.message_id && NEW_MESSAGE_TYPES.includes(message.type) && rememberMessageID(message.message_id)) {
+        return;
+    }
+    
+    // 忽略其他房间的消息，私信会话中不显示房间消息
+    if (message.room_id && (message.room_id !== currentRoomID || currentPeerID !== null)) {
+        return;
+    }
+    
     switch (message.type) {
         case MESSAGE_TYPES.TEXT:
         case MESSAGE_TYPES.IMAGE:
@@ -131,9 +330,34 @@ function handleMessage(message) {
         case MESSAGE_TYPES.FILE:
             renderMessage(message);
             break;
+        case MESSAGE_TYPES.DIRECT:
+            handleDirectMessage(message);
+            break;
         case MESSAGE_TYPES.SYSTEM:
             renderSystemMessage(message);
             break;
+        case MESSAGE_TYPES.WARNING:
+            // 只显示给自己的警告
+            renderSystemMessage(message, 'warning-message');
+            break;
+        case MESSAGE_TYPES.ERROR:
+            handleErrorReply(message);
+            break;
+        case MESSAGE_TYPES.ACK:
+            // 消息已被服务端处理
+            pendingRequests.delete(message.request_id);
+            return;
+        case MESSAGE_TYPES.RESYNC:
+            reloadCurrentView();
+            return;
+        case MESSAGE_TYPES.PRESENCE_JOIN:
+        case MESSAGE_TYPES.PRESENCE_UPDATE:
+            // 原地更新用户列表，不需要滚动
+            handlePresenceUpdate(message.data);
+            return;
+        case MESSAGE_TYPES.PRESENCE_LEAVE:
+            handlePresenceLeave(message.user_id);
+            return;
         case MESSAGE_TYPES.USER:
             // 用户信息更新
             if (message.user_id === localUserID) {
@@ -142,7 +366,7 @@ function handleMessage(message) {
             }
             break;
         case MESSAGE_TYPES.USERS:
-            // 更新在线用户列表
+            // 连接建立时收到的完整在线用户列表
             renderUserList(message.data);
             break;
         case MESSAGE_TYPES.STATS:
@@ -155,10 +379,38 @@ function handleMessage(message) {
             // 处理消息撤回
             handleRecalledMessage(message);
             break;
+        case MESSAGE_TYPES.REACTION:
+            // 更新消息的表情回应，不需要滚动
+            handleReactionUpdate(message);
+            return;
+        case MESSAGE_TYPES.EDIT:
+            // 原地替换被编辑的消息，不需要滚动
+            handleEditedMessage(message);
+            return;
+        case MESSAGE_TYPES.READ:
+            // 更新已读显示，不需要滚动
+            handleReadReceipt(message);
+            return;
+        case MESSAGE_TYPES.TYPING:
+            // 更新用户列表中的输入状态，不需要滚动
+            handleTypingNotice(message);
+            return;
+        case MESSAGE_TYPES.MENTION:
+            handleMention(message);
+            break;
+        case MESSAGE_TYPES.ROOM_JOIN:
+            // 已加入房间，加载房间历史消息
+            fetchMessages();
+            break;
+        case MESSAGE_TYPES.ROOMS:
+            // 更新房间列表
+            renderRoomList(message.data);
+            break;
     }
     
     // 滚动到底部
     scrollToBottom();
+    scheduleReadAck();
 }
 
 // 更新显示的用户名
@@ -173,8 +425,7 @@ function updateDisplayedUsername(username) {
 // 渲染普通消息
 function renderMessage(message) {
     // 判断是否是自己发送的消息
-    const isOwnMessage = message.ip === currentUserIP || 
-                        (message.user_id && message.user_id === localUserID);
+    const isOwnMessage = message.user_id && message.user_id === localUserID;
     const messageElement = document.createElement('div');
     messageElement.className = `message ${isOwnMessage ? 'own-message' : 'user-message'}`;
     messageElement.dataset.id = message.message_id;
@@ -209,6 +460,9 @@ function renderMessage(message) {
     
     messageInfo.appendChild(userInfo);
     messageInfo.appendChild(messageTime);
+    if (message.edited_at && message.status !== 1) {
+        markEdited(messageElement, messageInfo, message.message_id);
+    }
     
     // 消息内容
     const messageContent = document.createElement('div');
@@ -219,11 +473,12 @@ function renderMessage(message) {
         messageContent.textContent = '此消息已被撤回';
     } else {
         // 根据消息类型处理内容
-        if (message.type === MESSAGE_TYPES.TEXT) {
+        if (message.type === MESSAGE_TYPES.TEXT || message.type === MESSAGE_TYPES.DIRECT) {
             messageContent.textContent = message.content;
         } else if (message.type === MESSAGE_TYPES.IMAGE) {
             const img = document.createElement('img');
-            img.src = message.content;
+            // 新消息引用已上传的文件，旧消息内容为Base64数据
+            img.src = message.file_id ? fileURL(message.file_id) : message.content;
             img.alt = 'Image';
             img.loading = 'lazy';
             messageContent.appendChild(img);
@@ -253,7 +508,7 @@ function renderMessage(message) {
             fileDownload.className = 'file-download';
             fileDownload.textContent = '点击下载';
             fileDownload.onclick = function() {
-                downloadFile(message.content, message.file_name);
+                downloadFile(message.file_id ? fileURL(message.file_id) : message.content, message.file_name);
             };
             
             fileDetails.appendChild(fileName);
@@ -267,17 +522,47 @@ function renderMessage(message) {
         }
     }
     
-    // 添加消息操作按钮（仅自己的消息且未被撤回）
-    if (isOwnMessage && message.status !== 1) {
-        // 检查是否在8小时内
+    // 添加消息操作按钮（未被撤回的消息）
+    if (message.message_id && message.status !== 1) {
+        const messageActions = document.createElement('div');
+        messageActions.className = 'message-actions';
+        
+        const reactBtn = document.createElement('button');
+        reactBtn.className = 'message-action-btn';
+        reactBtn.textContent = '回应';
+        reactBtn.onclick = function(e) {
+            e.stopPropagation();
+            toggleReactionPicker(messageElement, message.message_id);
+        };
+        messageActions.appendChild(reactBtn);
+        
+        const replyBtn = document.createElement('button');
+        replyBtn.className = 'message-action-btn';
+        replyBtn.textContent = '回复';
+        replyBtn.onclick = function(e) {
+            e.stopPropagation();
+            startReply(message.message_id);
+        };
+        messageActions.appendChild(replyBtn);
+        
+        // 只有自己的文本消息可以编辑
+        if (isOwnMessage && (message.type === MESSAGE_TYPES.TEXT || message.type === MESSAGE_TYPES.DIRECT)) {
+            const editBtn = document.createElement('button');
+            editBtn.className = 'message-action-btn';
+            editBtn.textContent = '编辑';
+            editBtn.onclick = function(e) {
+                e.stopPropagation();
+                startEdit(messageElement, message.message_id);
+            };
+            messageActions.appendChild(editBtn);
+        }
+        
+        // 撤回按钮仅用于自己8小时内的消息
         const messageTime = message.created_at ? new Date(message.created_at) : new Date();
         const now = new Date();
         const hoursDiff = (now - messageTime) / (1000 * 60 * 60);
         
-        if (hoursDiff <= 8) {
-            const messageActions = document.createElement('div');
-            messageActions.className = 'message-actions';
-            
+        if (isOwnMessage && hoursDiff <= 8) {
             const recallBtn = document.createElement('button');
             recallBtn.className = 'message-action-btn';
             recallBtn.textContent = '撤回';
@@ -287,13 +572,34 @@ function renderMessage(message) {
             };
             
             messageActions.appendChild(recallBtn);
-            messageElement.appendChild(messageActions);
         }
+        
+        // 管理员可以删除其他人的消息
+        if (isAdmin && !isOwnMessage) {
+            const deleteBtn = document.createElement('button');
+            deleteBtn.className = 'message-action-btn';
+            deleteBtn.textContent = '删除';
+            deleteBtn.onclick = function(e) {
+                e.stopPropagation();
+                adminDeleteMessage(message.message_id);
+            };
+            messageActions.appendChild(deleteBtn);
+        }
+        
+        messageElement.appendChild(messageActions);
     }
     
     // 组装消息
     messageElement.appendChild(messageInfo);
+    if (message.reply_to) {
+        messageElement.appendChild(renderReplyQuote(message.reply_to));
+    }
     messageElement.appendChild(messageContent);
+    renderReplyCount(messageElement, message.message_id, message.reply_count || 0);
+    
+    if (message.status !== 1) {
+        renderReactions(messageElement, message.message_id, message.reactions);
+    }
     
     messagesContainer.appendChild(messageElement);
     
@@ -301,6 +607,313 @@ function renderMessage(message) {
     if (message.message_id) {
         messageMap.set(message.message_id, messageElement);
     }
+    
+    // 实时收到的回复使被回复消息的回复数量加一
+    if (message.reply_to && message.reply_count === undefined) {
+        const parentElement = messageMap.get(message.reply_to);
+        if (parentElement) {
+            const count = parseInt(parentElement.dataset.replyCount || '0', 10) + 1;
+            renderReplyCount(parentElement, message.reply_to, count);
+        }
+    }
+}
+
+// 渲染被回复消息的引用，点击时滚动到原消息
+function renderReplyQuote(parentId) {
+    const quote = document.createElement('div');
+    quote.className = 'reply-quote';
+    quote.textContent = describeMessage(parentId);
+    quote.onclick = function(e) {
+        e.stopPropagation();
+        const parentElement = messageMap.get(parentId);
+        if (parentElement) {
+            parentElement.scrollIntoView({ behavior: 'smooth', block: 'center' });
+        }
+    };
+    return quote;
+}
+
+// 生成消息的简短描述，用于回复引用和回复提示
+function describeMessage(messageId) {
+    const element = messageMap.get(messageId);
+    if (!element) {
+        return '回复一条较早的消息';
+    }
+    const author = element.querySelector('.user-info').textContent;
+    let text = element.querySelector('.message-content').textContent.trim();
+    if (text.length > 40) {
+        text = text.slice(0, 40) + '…';
+    }
+    return `${author}: ${text}`;
+}
+
+// 显示消息的回复数量，点击时打开话题
+function renderReplyCount(messageElement, messageId, count) {
+    messageElement.dataset.replyCount = count;
+    let link = messageElement.querySelector('.thread-link');
+    if (count === 0) {
+        if (link) {
+            link.remove();
+        }
+        return;
+    }
+    
+    if (!link) {
+        link = document.createElement('div');
+        link.className = 'thread-link';
+        link.onclick = function(e) {
+            e.stopPropagation();
+            openThread(messageId);
+        };
+        // 回复数量显示在消息内容之后、表情回应之前
+        messageElement.querySelector('.message-content').after(link);
+    }
+    link.textContent = `${count} 条回复`;
+}
+
+// 开始回复指定消息
+function startReply(messageId) {
+    replyToID = messageId;
+    replyPreview.textContent = `回复 ${describeMessage(messageId)}`;
+    replyBar.classList.add('active');
+    messageInput.focus();
+}
+
+// 取消回复
+function cancelReply() {
+    replyToID = null;
+    replyBar.classList.remove('active');
+}
+
+// 在消息内容中直接编辑，回车保存，Esc取消
+function startEdit(messageElement, messageId) {
+    const messageContent = messageElement.querySelector('.message-content');
+    const originalContent = messageContent.textContent;
+    
+    messageContent.contentEditable = 'true';
+    messageContent.classList.add('editing');
+    messageContent.focus();
+    
+    const finish = function(save) {
+        messageContent.removeEventListener('keydown', onKeydown);
+        messageContent.removeEventListener('blur', onBlur);
+        messageContent.contentEditable = 'false';
+        messageContent.classList.remove('editing');
+        
+        const newContent = messageContent.textContent.trim();
+        // 先恢复原内容，以服务端广播的编辑结果为准
+        messageContent.textContent = originalContent;
+        if (save && newContent !== '' && newContent !== originalContent) {
+            sendMessage({
+                type: MESSAGE_TYPES.EDIT,
+                message_id: messageId,
+                content: newContent
+            });
+        }
+    };
+    const onKeydown = function(e) {
+        if (e.key === 'Enter' && !e.shiftKey) {
+            e.preventDefault();
+            finish(true);
+        } else if (e.key === 'Escape') {
+            finish(false);
+        }
+    };
+    const onBlur = function() {
+        finish(true);
+    };
+    
+    messageContent.addEventListener('keydown', onKeydown);
+    messageContent.addEventListener('blur', onBlur);
+}
+
+// 显示"已编辑"标记，点击时查看编辑历史
+function markEdited(messageElement, messageInfo, messageId) {
+    if (messageElement.querySelector('.message-edited')) {
+        return;
+    }
+    
+    const marker = document.createElement('span');
+    marker.className = 'message-edited';
+    marker.textContent = '(已编辑)';
+    marker.onclick = function(e) {
+        e.stopPropagation();
+        openEditHistory(messageId);
+    };
+    messageInfo.appendChild(marker);
+}
+
+// 收到消息编辑通知时替换消息内容
+function handleEditedMessage(message) {
+    const messageElement = messageMap.get(message.message_id);
+    if (!messageElement) {
+        return;
+    }
+    
+    messageElement.querySelector('.message-content').textContent = message.content;
+    markEdited(messageElement, messageElement.querySelector('.message-info'), message.message_id);
+}
+
+// 在侧边面板中显示消息的编辑历史
+function openEditHistory(messageId) {
+    fetch(`/api/messages/${messageId}/edits`)
+        .then(response => response.json())
+        .then(history => {
+            if (history.error) {
+                console.error('获取编辑历史失败:', history.error);
+                return;
+            }
+            
+            // 历史版本按时间排列，最后是当前内容
+            const versions = (history.edits || []).map(edit => ({
+                label: `${formatTime(new Date(edit.edited_at))} 之前`,
+                content: edit.content
+            }));
+            versions.push({ label: '当前内容', content: history.message.content });
+            
+            threadTitle.textContent = '编辑历史';
+            threadMessages.innerHTML = '';
+            versions.forEach(version => {
+                const item = document.createElement('div');
+                item.className = 'thread-item';
+                
+                const label = document.createElement('div');
+                label.className = 'thread-author';
+                label.textContent = version.label;
+                
+                const content = document.createElement('div');
+                content.textContent = version.content;
+                
+                item.appendChild(label);
+                item.appendChild(content);
+                threadMessages.appendChild(item);
+            });
+            
+            threadPanel.classList.add('active');
+        })
+        .catch(error => console.error('获取编辑历史失败:', error));
+}
+
+// 打开话题，显示原消息和全部回复
+function openThread(messageId) {
+    fetch(`/api/messages/${messageId}/thread`)
+        .then(response => response.json())
+        .then(thread => {
+            if (thread.error) {
+                console.error('获取话题失败:', thread.error);
+                return;
+            }
+            
+            threadTitle.textContent = '话题';
+            threadMessages.innerHTML = '';
+            [thread.message, ...(thread.replies || [])].forEach(message => {
+                const item = document.createElement('div');
+                item.className = 'thread-item';
+                
+                const author = document.createElement('div');
+                author.className = 'thread-author';
+                author.textContent = `${message.username || '用户' + message.user_id} ${formatTime(new Date(message.created_at))}`;
+                
+                const content = document.createElement('div');
+                if (message.status === 1) {
+                    content.textContent = '此消息已被撤回';
+                } else {
+                    content.textContent = message.file_name || message.content;
+                }
+                
+                item.appendChild(author);
+                item.appendChild(content);
+                threadMessages.appendChild(item);
+            });
+            
+            threadPanel.classList.add('active');
+        })
+        .catch(error => console.error('获取话题失败:', error));
+}
+
+// 关闭话题
+function closeThread() {
+    threadPanel.classList.remove('active');
+}
+
+// 处理收到的私信
+function handleDirectMessage(message) {
+    const peerID = message.user_id === localUserID ? message.target_id : message.user_id;
+    if (peerID === currentPeerID) {
+        renderMessage(message);
+        return;
+    }
+    
+    // 不在该会话中时提示收到私信
+    if (message.user_id !== localUserID) {
+        fetchUnreadCounts();
+        renderSystemMessage({
+            content: `收到来自 ${message.username || message.ip || '用户' + message.user_id} 的私信`
+        });
+    }
+}
+
+// 打开与指定用户的私信会话
+function openConversation(user) {
+    stopTypingNotice();
+    currentPeerID = user.id;
+    messageMap.clear();
+    readPositions.clear();
+    typingUsers.clear();
+    cancelReply();
+    closeThread();
+    conversationTitle.textContent = `与 ${user.username || user.ip} 的私信`;
+    conversationBar.classList.add('active');
+    renderUnreadBadges();
+    renderTypingIndicators();
+    fetchConversation(user.id);
+}
+
+// 关闭私信会话，返回当前房间
+function closeConversation() {
+    stopTypingNotice();
+    currentPeerID = null;
+    messageMap.clear();
+    readPositions.clear();
+    typingUsers.clear();
+    cancelReply();
+    closeThread();
+    conversationBar.classList.remove('active');
+    renderUnreadBadges();
+    renderTypingIndicators();
+    fetchMessages();
+}
+
+// 获取私信记录
+function fetchConversation(peerID) {
+    fetch(`/api/conversations/${peerID}`)
+        .then(response => response.json())
+        .then(messages => {
+            messagesContainer.innerHTML = '';
+            
+            (messages || []).forEach(message => {
+                rememberMessageID(message.id);
+                renderMessage({
+                    type: MESSAGE_TYPES.DIRECT,
+                    content: message.content,
+                    username: message.username,
+                    user_id: message.user_id,
+                    target_id: message.recipient_id,
+                    message_id: message.id,
+                    status: message.status,
+                    created_at: message.created_at,
+                    reactions: message.reactions,
+                    reply_to: message.reply_to,
+                    reply_count: message.reply_count,
+                    edited_at: message.edited_at
+                });
+            });
+            
+            scrollToBottom();
+            fetchReadPositions(`peer=${peerID}`);
+            scheduleReadAck();
+        })
+        .catch(error => console.error('获取私信记录失败:', error));
 }
 
 // 处理已撤回的消息
@@ -313,20 +926,92 @@ function handleRecalledMessage(message) {
         
         // 更新消息内容
         const messageContent = messageElement.querySelector('.message-content');
-        messageContent.textContent = '此消息已被撤回';
+        const deletedByAdmin = message.data && message.data.deleted_by_admin;
+        messageContent.textContent = deletedByAdmin ? '此消息已被管理员删除' : '此消息已被撤回';
         
-        // 移除操作按钮
-        const actionButtons = messageElement.querySelector('.message-actions');
-        if (actionButtons) {
-            actionButtons.remove();
+        // 移除操作按钮和表情回应
+        messageElement.querySelectorAll('.message-actions, .message-reactions, .reaction-picker, .message-edited').forEach(el => el.remove());
+    }
+}
+
+// 渲染消息下方的表情回应，已有的回应行会被替换
+function renderReactions(messageElement, messageId, reactions) {
+    const existing = messageElement.querySelector('.message-reactions');
+    if (existing) {
+        existing.remove();
+    }
+    if (!reactions || reactions.length === 0) {
+        return;
+    }
+    
+    const container = document.createElement('div');
+    container.className = 'message-reactions';
+    
+    reactions.forEach(reaction => {
+        const chip = document.createElement('button');
+        chip.className = 'reaction-chip';
+        if ((reaction.user_ids || []).includes(localUserID)) {
+            chip.classList.add('own-reaction');
         }
+        chip.textContent = `${reaction.emoji} ${reaction.count}`;
+        chip.onclick = function(e) {
+            e.stopPropagation();
+            sendReaction(messageId, reaction.emoji);
+        };
+        container.appendChild(chip);
+    });
+    
+    messageElement.appendChild(container);
+}
+
+// 显示或隐藏消息的快捷表情选择框
+function toggleReactionPicker(messageElement, messageId) {
+    const existing = messageElement.querySelector('.reaction-picker');
+    if (existing) {
+        existing.remove();
+        return;
+    }
+    
+    const picker = document.createElement('div');
+    picker.className = 'reaction-picker';
+    QUICK_REACTIONS.forEach(emoji => {
+        const option = document.createElement('span');
+        option.className = 'reaction-option';
+        option.textContent = emoji;
+        option.onclick = function(e) {
+            e.stopPropagation();
+            sendReaction(messageId, emoji);
+            picker.remove();
+        };
+        picker.appendChild(option);
+    });
+    
+    messageElement.appendChild(picker);
+}
+
+// 添加或取消表情回应
+function sendReaction(messageId, emoji) {
+    if (!messageId) return;
+    
+    sendMessage({
+        type: MESSAGE_TYPES.REACTION,
+        message_id: messageId,
+        emoji: emoji
+    });
+}
+
+// 收到表情回应变化时，用服务端的汇总替换消息的回应
+function handleReactionUpdate(message) {
+    const messageElement = messageMap.get(message.message_id);
+    if (messageElement && message.data) {
+        renderReactions(messageElement, message.message_id, message.data.reactions);
     }
 }
 
 // 渲染系统消息
-function renderSystemMessage(message) {
+function renderSystemMessage(message, extraClass) {
     const messageElement = document.createElement('div');
-    messageElement.className = 'message system-message';
+    messageElement.className = 'message system-message' + (extraClass ? ' ' + extraClass : '');
     
     const messageContent = document.createElement('div');
     messageContent.className = 'message-content';
@@ -336,6 +1021,26 @@ function renderSystemMessage(message) {
     messagesContainer.appendChild(messageElement);
 }
 
+// 处理服务端拒绝消息的回复
+function handleErrorReply(message) {
+    const pending = message.request_id ? pendingRequests.get(message.request_id) : null;
+    pendingRequests.delete(message.request_id);
+    
+    // 超出频率限制时服务端已单独发送警告
+    const code = message.data ? message.data.code : '';
+    if (code === 'rate_limited' && !pending) {
+        return;
+    }
+    
+    // 发送失败的文本放回输入框，便于修改后重新发送
+    if (pending && [MESSAGE_TYPES.TEXT, MESSAGE_TYPES.DIRECT].includes(pending.type) && !messageInput.value) {
+        messageInput.value = pending.content;
+    }
+    
+    const content = pending ? '发送失败：' + message.content : message.content;
+    renderSystemMessage({ content: content }, 'warning-message');
+}
+
 // 撤回消息
 function recallMessage(messageId) {
     if (!messageId) return;
@@ -348,10 +1053,15 @@ function recallMessage(messageId) {
     sendMessage(message);
 }
 
+// 文件下载地址
+function fileURL(fileID) {
+    return `/api/files/${fileID}`;
+}
+
 // 下载文件
-function downloadFile(dataUrl, fileName) {
+function downloadFile(url, fileName) {
     const link = document.createElement('a');
-    link.href = dataUrl;
+    link.href = url;
     link.download = fileName;
     document.body.appendChild(link);
     link.click();
@@ -383,66 +1093,317 @@ function initEmojis() {
     });
 }
 
-// 获取历史消息
+// 获取当前房间最新的一页历史消息
 function fetchMessages() {
-    fetch('/api/messages')
+    const roomID = currentRoomID;
+    fetch(`/api/messages?room=${roomID}&limit=${HISTORY_PAGE_SIZE}`)
         .then(response => response.json())
-        .then(messages => {
+        .then(page => {
+            if (roomID !== currentRoomID) return;
+            
             // 清空消息容器
             messagesContainer.innerHTML = '';
+            historyCursor = page.next_cursor;
             
             // 按时间顺序渲染消息
-            messages.forEach(message => {
-                // 转换消息类型
-                let msgType;
-                switch (message.type) {
-                    case 0:
-                        msgType = MESSAGE_TYPES.TEXT;
-                        break;
-                    case 1:
-                        msgType = MESSAGE_TYPES.IMAGE;
-                        break;
-                    case 2:
-                        msgType = MESSAGE_TYPES.EMOJI;
-                        break;
-                    case 3:
-                        msgType = MESSAGE_TYPES.SYSTEM;
-                        break;
-                    case 4:
-                        msgType = MESSAGE_TYPES.FILE;
-                        break;
-                    default:
-                        msgType = MESSAGE_TYPES.TEXT;
-                }
-                
-                // 构造消息对象
-                const wsMessage = {
-                    type: msgType,
-                    content: message.content,
-                    username: message.username,
-                    ip: '', // 历史消息可能没有IP
-                    message_id: message.id,
-                    status: message.status,
-                    file_name: message.file_name,
-                    file_size: message.file_size
-                };
-                
-                // 根据消息类型渲染
-                if (message.type === 3) { // 系统消息
-                    renderSystemMessage(wsMessage);
-                } else {
-                    renderMessage(wsMessage);
-                }
-            });
+            (page.messages || []).forEach(renderHistoryMessage);
             
             // 滚动到底部
             scrollToBottom();
+            fetchReadPositions(`room=${roomID}`);
+            scheduleReadAck();
         })
         .catch(error => {
             console.error('获取历史消息失败:', error);
         });
 }
 
+// 滚动到顶部时加载更早的一页消息，插入到现有消息之前并保持滚动位置
+function fetchOlderMessages() {
+    if (!historyCursor || loadingHistory || currentPeerID !== null) return;
+    
+    const roomID = currentRoomID;
+    loadingHistory = true;
+    fetch(`/api/messages?room=${roomID}&before=${historyCursor}&limit=${HISTORY_PAGE_SIZE}`)
+        .then(response => response.json())
+        .then(page => {
+            if (roomID !== currentRoomID || currentPeerID !== null) return;
+            
+            const firstExisting = messagesContainer.firstChild;
+            const existingCount = messagesContainer.children.length;
+            const previousHeight = messagesContainer.scrollHeight;
+            
+            // 先按顺序追加到末尾，再整体移到原有消息之前
+            (page.messages || []).forEach(renderHistoryMessage);
+            const older = Array.from(messagesContainer.children).slice(existingCount);
+            older.forEach(element => messagesContainer.insertBefore(element, firstExisting));
+            
+            messagesContainer.scrollTop += messagesContainer.scrollHeight - previousHeight;
+            historyCursor = page.next_cursor;
+            renderSeenBy();
+        })
+        .catch(error => {
+            console.error('获取历史消息失败:', error);
+        })
+        .finally(() => {
+            loadingHistory = false;
+        });
+}
+
+// 渲染一条历史消息
+function renderHistoryMessage(message) {
+    // 转换消息类型
+    let msgType;
+    switch (message.type) {
+        case 0:
+            msgType = MESSAGE_TYPES.TEXT;
+            break;
+        case 1:
+            msgType = MESSAGE_TYPES.IMAGE;
+            break;
+        case 2:
+            msgType = MESSAGE_TYPES.EMOJI;
+            break;
+        case 3:
+            msgType = MESSAGE_TYPES.SYSTEM;
+            break;
+        case 4:
+            msgType = MESSAGE_TYPES.FILE;
+            break;
+        default:
+            msgType = MESSAGE_TYPES.TEXT;
+    }
+    
+    rememberMessageID(message.id);
+    
+    // 构造消息对象
+    const wsMessage = {
+        type: msgType,
+        content: message.content,
+        username: message.username,
+        user_id: message.user_id,
+        ip: '', // 历史消息可能没有IP
+        message_id: message.id,
+        status: message.status,
+        file_name: message.file_name,
+        file_size: message.file_size,
+        file_id: message.file_id,
+        room_id: message.room_id,
+        created_at: message.created_at,
+        reactions: message.reactions,
+        reply_to: message.reply_to,
+        reply_count: message.reply_count,
+        edited_at: message.edited_at
+    };
+    
+    // 根据消息类型渲染
+    if (message.type === 3) { // 系统消息
+        renderSystemMessage(wsMessage);
+    } else {
+        renderMessage(wsMessage);
+    }
+}
+
+// 当前显示的房间或私信会话中最新一条消息的ID
+function latestRenderedMessageID() {
+    let latest = 0;
+    messageMap.forEach((element, id) => {
+        if (id > latest) latest = id;
+    });
+    return latest;
+}
+
+// 页面可见时延迟发送已读回执，确认已显示的最新消息
+function scheduleReadAck() {
+    if (readAckTimer !== null || document.hidden) return;
+    
+    readAckTimer = setTimeout(() => {
+        readAckTimer = null;
+        const messageId = latestRenderedMessageID();
+        if (!messageId || document.hidden) return;
+        
+        const ack = currentPeerID !== null
+            ? { type: MESSAGE_TYPES.READ, message_id: messageId, target_id: currentPeerID, room_id: 0 }
+            : { type: MESSAGE_TYPES.READ, message_id: messageId, room_id: currentRoomID };
+        const key = `${ack.room_id}:${ack.target_id || 0}:${messageId}`;
+        if (key === lastReadAck) return;
+        lastReadAck = key;
+        sendMessage(ack);
+    }, READ_ACK_DELAY);
+}
+
+// 处理已读回执通知
+function handleReadReceipt(message) {
+    // 私信的已读通知只在对应的会话中显示
+    if (!message.room_id) {
+        const peerID = message.user_id === localUserID ? message.target_id : message.user_id;
+        if (peerID !== currentPeerID) return;
+    }
+    
+    // 自己的已读位置变化后刷新未读数量
+    if (message.user_id === localUserID) {
+        fetchUnreadCounts();
+        return;
+    }
+    
+    readPositions.set(message.user_id, {
+        username: message.username,
+        last_read_id: message.message_id
+    });
+    renderSeenBy();
+}
+
+// 获取当前房间或会话中各用户的已读位置，query为room=ID或peer=ID
+function fetchReadPositions(query) {
+    fetch(`/api/reads?${query}`)
+        .then(response => response.json())
+        .then(states => {
+            readPositions.clear();
+            (Array.isArray(states) ? states : []).forEach(state => {
+                if (state.user_id === localUserID) return;
+                readPositions.set(state.user_id, {
+                    username: state.username,
+                    last_read_id: state.last_read_id
+                });
+            });
+            renderSeenBy();
+        })
+        .catch(error => console.error('获取已读位置失败:', error));
+}
+
+// 在每个用户已读到的最后一条消息下方显示"已读"
+function renderSeenBy() {
+    messagesContainer.querySelectorAll('.seen-by').forEach(element => element.remove());
+    
+    const ids = Array.from(messageMap.keys()).sort((a, b) => a - b);
+    const readers = new Map(); // 消息ID到已读用户名列表
+    readPositions.forEach(position => {
+        let target = null;
+        for (const id of ids) {
+            if (id > position.last_read_id) break;
+            target = id;
+        }
+        if (target === null) return;
+        if (!readers.has(target)) readers.set(target, []);
+        readers.get(target).push(position.username);
+    });
+    
+    readers.forEach((names, id) => {
+        const seenBy = document.createElement('div');
+        seenBy.className = 'seen-by';
+        seenBy.textContent = `已读：${names.join('、')}`;
+        messageMap.get(id).appendChild(seenBy);
+    });
+}
+
+// 获取未读消息数量
+function fetchUnreadCounts() {
+    fetch('/api/unread')
+        .then(response => response.json())
+        .then(counts => {
+            if (counts.error) return;
+            unreadCounts = counts;
+            renderUnreadBadges();
+        })
+        .catch(error => console.error('获取未读数量失败:', error));
+}
+
+// 在房间列表和用户列表中显示未读数量，当前打开的房间或会话不显示
+function renderUnreadBadges() {
+    const setBadge = (element, count) => {
+        let badge = element.querySelector('.unread-badge');
+        if (!count) {
+            if (badge) badge.remove();
+            return;
+        }
+        if (!badge) {
+            badge = document.createElement('span');
+            badge.className = 'unread-badge';
+            element.appendChild(badge);
+        }
+        badge.textContent = count > 99 ? '99+' : count;
+    };
+    
+    roomList.querySelectorAll('.room-item').forEach(element => {
+        const roomID = parseInt(element.dataset.roomId, 10);
+        const isOpen = roomID === currentRoomID && currentPeerID === null;
+        setBadge(element, isOpen ? 0 : unreadCounts.rooms[roomID]);
+    });
+    userList.querySelectorAll('.user-item').forEach(element => {
+        const userID = parseInt(element.dataset.userId, 10);
+        setBadge(element.querySelector('.user-item-name'), userID === currentPeerID ? 0 : unreadCounts.direct[userID]);
+    });
+}
+
+// 获取房间列表
+function fetchRooms() {
+    fetch('/api/rooms')
+        .then(response => response.json())
+        .then(rooms => {
+            renderRoomList(rooms);
+            fetchUnreadCounts();
+        })
+        .catch(error => console.error('获取房间列表失败:', error));
+}
+
+// 渲染房间列表
+function renderRoomList(rooms) {
+    roomList.innerHTML = '';
+    
+    (rooms || []).forEach(room => {
+        const roomElement = document.createElement('div');
+        roomElement.className = 'room-item';
+        if (room.id === currentRoomID) {
+            roomElement.classList.add('active');
+        }
+        roomElement.dataset.roomId = room.id;
+        roomElement.textContent = room.name;
+        roomElement.addEventListener('click', () => switchRoom(room.id));
+        roomList.appendChild(roomElement);
+    });
+    renderUnreadBadges();
+}
+
+// 切换房间
+function switchRoom(roomID) {
+    if (roomID === currentRoomID) return;
+    
+    sendMessage({ type: MESSAGE_TYPES.ROOM_LEAVE, room_id: currentRoomID });
+    currentRoomID = roomID;
+    messageMap.clear();
+    readPositions.clear();
+    typingUsers.clear();
+    cancelReply();
+    closeThread();
+    sendMessage({ type: MESSAGE_TYPES.ROOM_JOIN, room_id: roomID });
+    
+    fetchRooms();
+}
+
+// 创建房间
+function createRoom() {
+    const name = roomNameInput.value.trim();
+    if (!name) return;
+    
+    fetch('/api/rooms', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ name })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.error) {
+            alert(data.error);
+            return;
+        }
+        roomNameInput.value = '';
+        switchRoom(data.id);
+    })
+    .catch(error => console.error('创建房间失败:', error));
+}
+
 // 获取在线用户
 function fetchOnlineUsers() {
     fetch('/api/users/online')
@@ -453,50 +1414,212 @@ function fetchOnlineUsers() {
         .catch(error => console.error('获取在线用户失败:', error));
 }
 
-// 渲染用户列表
+// 渲染用户列表，连接建立时服务端发送一次完整列表
 function renderUserList(users) {
     userList.innerHTML = '';
-    
-    if (!users || users.length === 0) {
+    (users || []).forEach(user => {
+        userList.appendChild(createUserItem(user));
+        syncOwnUser(user);
+    });
+    renderEmptyUserList();
+    renderUnreadBadges();
+    renderTypingIndicators();
+}
+
+// 没有在线用户时显示提示
+function renderEmptyUserList() {
+    const placeholder = userList.querySelector('.user-list-empty');
+    const empty = !userList.querySelector('.user-item');
+    if (empty && !placeholder) {
         const noUsers = document.createElement('div');
+        noUsers.className = 'user-list-empty';
         noUsers.textContent = '暂无在线用户';
         userList.appendChild(noUsers);
-        return;
+    } else if (!empty && placeholder) {
+        placeholder.remove();
     }
+}
+
+// 创建用户列表项
+function createUserItem(user) {
+    const userElement = document.createElement('div');
+    userElement.className = 'user-item';
+    userElement.dataset.userId = user.id;
     
-    users.forEach(user => {
-        const userElement = document.createElement('div');
-        userElement.className = 'user-item';
-        
-        const userName = document.createElement('div');
-        userName.className = 'user-item-name';
-        userName.textContent = user.username || '未设置昵称';
-        
-        const userIp = document.createElement('div');
-        userIp.className = 'user-item-ip';
-        userIp.textContent = `IP: ${user.ip}`;
-        
-        const userTime = document.createElement('div');
-        userTime.className = 'user-item-time';
-        const lastOnline = new Date(user.last_online);
-        userTime.textContent = `最后在线: ${formatDateTime(lastOnline)}`;
-        
-        userElement.appendChild(userName);
-        userElement.appendChild(userIp);
-        userElement.appendChild(userTime);
-        userList.appendChild(userElement);
+    const userName = document.createElement('div');
+    userName.className = 'user-item-name';
+    userName.textContent = user.username || '未设置昵称';
+    
+    const userIp = document.createElement('div');
+    userIp.className = 'user-item-ip';
+    userIp.textContent = `IP: ${user.ip}`;
+    
+    // 同时在多个设备上在线时显示设备数量，悬停查看各设备的IP
+    if (user.devices && user.devices.length > 1) {
+        userIp.textContent += `（${user.devices.length}个设备）`;
+        userIp.title = user.devices.map(device => `${device.ip} ${device.user_agent}`).join('\n');
+    }
+    
+    const userTime = document.createElement('div');
+    userTime.className = 'user-item-time';
+    const lastOnline = new Date(user.last_online);
+    userTime.textContent = `最后在线: ${formatDateTime(lastOnline)}`;
+    
+    userElement.appendChild(userName);
+    renderPresence(userElement, user.presence, user.status_text);
+    userElement.appendChild(userIp);
+    userElement.appendChild(userTime);
+    
+    // 其他用户可以发起私信
+    if (user.id !== localUserID) {
+        const dmButton = document.createElement('button');
+        dmButton.className = 'user-item-dm';
+        dmButton.textContent = '私信';
+        dmButton.addEventListener('click', () => openConversation(user));
+        userElement.appendChild(dmButton);
         
-        // 如果是当前用户，保存用户ID和更新用户名输入框
-        if (user.ip === currentUserIP) {
-            localUserID = user.id;
-            if (user.username) {
-                usernameInput.value = user.username;
-                updateDisplayedUsername(user.username);
-            }
+        if (isAdmin && !user.is_admin) {
+            userElement.appendChild(renderAdminActions(user));
+        }
+    }
+    return userElement;
+}
+
+// 列表中的用户是自己时，同步昵称和自己设置的在线状态
+function syncOwnUser(user) {
+    if (user.id !== localUserID) return;
+    
+    if (user.username) {
+        usernameInput.value = user.username;
+        updateDisplayedUsername(user.username);
+    }
+    // 因空闲自动显示的离开不改变自己设置的状态
+    if (!user.idle) {
+        presenceSelect.value = user.presence;
+        statusTextInput.value = user.status_text || '';
+    }
+}
+
+// 输入框内容变化时通知其他用户正在输入，清空输入框时停止
+function notifyTyping() {
+    if (!messageInput.value.trim()) {
+        stopTypingNotice();
+        return;
+    }
+    
+    const now = Date.now();
+    if (typingTarget !== null && now - lastTypingSent < TYPING_REFRESH) return;
+    lastTypingSent = now;
+    
+    typingTarget = currentPeerID !== null
+        ? { target_id: currentPeerID, room_id: 0 }
+        : { room_id: currentRoomID };
+    sendMessage({ type: MESSAGE_TYPES.TYPING, ...typingTarget });
+}
+
+// 通知停止输入
+function stopTypingNotice() {
+    if (typingTarget === null) return;
+    
+    sendMessage({ type: MESSAGE_TYPES.TYPING, ...typingTarget, data: { typing: false } });
+    typingTarget = null;
+}
+
+// 处理其他用户的输入状态通知
+function handleTypingNotice(message) {
+    const scope = message.room_id ? `room:${message.room_id}` : `direct:${message.target_id}`;
+    const scopes = typingUsers.get(message.user_id) || new Set();
+    if (message.data && message.data.typing) {
+        scopes.add(scope);
+    } else {
+        scopes.delete(scope);
+    }
+    
+    if (scopes.size > 0) {
+        typingUsers.set(message.user_id, scopes);
+    } else {
+        typingUsers.delete(message.user_id);
+    }
+    renderTypingIndicators();
+}
+
+// 在用户列表中标记正在输入的用户
+function renderTypingIndicators() {
+    userList.querySelectorAll('.user-item').forEach(element => {
+        const userID = parseInt(element.dataset.userId, 10);
+        let indicator = element.querySelector('.user-item-typing');
+        if (!typingUsers.has(userID)) {
+            if (indicator) indicator.remove();
+            return;
+        }
+        if (!indicator) {
+            indicator = document.createElement('div');
+            indicator.className = 'user-item-typing';
+            indicator.textContent = '正在输入…';
+            element.querySelector('.user-item-name').after(indicator);
         }
     });
 }
 
+// 管理员对用户的操作按钮
+function renderAdminActions(user) {
+    const actions = document.createElement('div');
+    actions.className = 'user-item-admin';
+    
+    const addAction = (label, handler) => {
+        const button = document.createElement('button');
+        button.textContent = label;
+        button.addEventListener('click', handler);
+        actions.appendChild(button);
+    };
+    
+    const muted = user.muted_until && new Date(user.muted_until) > new Date();
+    if (muted) {
+        addAction('解除禁言', () => adminRequest('DELETE', `/api/admin/users/${user.id}/mute`));
+    } else {
+        addAction('禁言', () => {
+            const duration = prompt('禁言时长（例如 10m、1h）', '10m');
+            if (duration) adminRequest('POST', `/api/admin/users/${user.id}/mute`, { duration });
+        });
+    }
+    addAction('踢出', () => {
+        if (confirm(`确定把 ${user.username || user.ip} 移出聊天室吗？`)) {
+            adminRequest('POST', `/api/admin/users/${user.id}/kick`);
+        }
+    });
+    addAction('封禁', () => {
+        const duration = prompt('封禁时长（例如 1h、24h，留空为永久封禁）', '');
+        if (duration === null) return;
+        const reason = prompt('封禁原因（可选）', '') || '';
+        adminRequest('POST', `/api/admin/users/${user.id}/ban`, { duration, reason });
+    });
+    return actions;
+}
+
+// 发送管理员请求，失败时提示错误
+function adminRequest(method, url, body) {
+    const options = { method };
+    if (body) {
+        options.headers = { 'Content-Type': 'application/json' };
+        options.body = JSON.stringify(body);
+    }
+    return fetch(url, options)
+        .then(response => response.json())
+        .then(data => {
+            if (data.error) {
+                alert(data.error);
+            }
+            return data;
+        })
+        .catch(error => console.error('管理操作失败:', error));
+}
+
+// 管理员删除消息
+function adminDeleteMessage(messageId) {
+    if (!confirm('确定删除这条消息吗？')) return;
+    adminRequest('DELETE', `/api/admin/messages/${messageId}`);
+}
+
 // 获取聊天室统计信息
 function fetchStats() {
     fetch('/api/stats')
@@ -531,31 +1654,48 @@ function renderStats(data) {
     statsContent.appendChild(onlineUserCount);
 }
 
-// 搜索消息
-function searchMessages() {
+// 搜索消息，offset不为0时在已有结果后追加下一页
+function searchMessages(offset) {
     const query = searchInput.value.trim();
     if (!query) return;
     
-    fetch(`/api/messages/search?q=${encodeURIComponent(query)}`)
+    const params = new URLSearchParams({ q: query });
+    if (searchType.value) params.set('type', searchType.value);
+    if (searchCurrentRoom.checked) params.set('room', currentRoomID);
+    if (searchFrom.value) params.set('from', searchFrom.value);
+    if (searchTo.value) params.set('to', searchTo.value);
+    if (offset) params.set('offset', offset);
+    
+    fetch(`/api/messages/search?${params}`)
         .then(response => response.json())
-        .then(messages => {
-            renderSearchResults(messages);
+        .then(data => {
+            if (data.error) {
+                console.error('搜索消息失败:', data.error);
+                return;
+            }
+            renderSearchResults(data.results || [], data.next_offset, Boolean(offset));
         })
         .catch(error => console.error('搜索消息失败:', error));
 }
 
-// 渲染搜索结果
-function renderSearchResults(messages) {
-    searchResults.innerHTML = '';
+// 渲染搜索结果，摘要由服务端转义并用<mark>标记命中的关键字
+function renderSearchResults(results, nextOffset, append) {
+    if (!append) {
+        searchResults.innerHTML = '';
+    }
+    const moreButton = searchResults.querySelector('.search-more');
+    if (moreButton) {
+        moreButton.remove();
+    }
     
-    if (messages.length === 0) {
+    if (!append && results.length === 0) {
         const noResults = document.createElement('div');
         noResults.textContent = '未找到匹配的消息';
         searchResults.appendChild(noResults);
         return;
     }
     
-    messages.forEach(message => {
+    results.forEach(message => {
         const searchItem = document.createElement('div');
         searchItem.className = 'search-item';
         
@@ -563,7 +1703,7 @@ function renderSearchResults(messages) {
         searchItemInfo.className = 'search-item-info';
         
         const userInfo = document.createElement('span');
-        userInfo.textContent = `${message.username || message.ip}`;
+        userInfo.textContent = `${message.username || '用户' + message.user_id}`;
         
         const timeInfo = document.createElement('span');
         const msgTime = new Date(message.created_at);
@@ -573,26 +1713,134 @@ function renderSearchResults(messages) {
         searchItemInfo.appendChild(timeInfo);
         
         const contentPreview = document.createElement('div');
-        if (message.type === 'text') {
-            contentPreview.textContent = message.content;
-        } else if (message.type === 'image') {
-            contentPreview.textContent = '[图片消息]';
-        } else if (message.type === 'emoji') {
-            contentPreview.textContent = '[表情消息]';
+        contentPreview.className = 'search-snippet';
+        if (message.type === 1) {
+            contentPreview.innerHTML = `[图片] ${message.snippet}`;
+        } else if (message.type === 4) {
+            contentPreview.innerHTML = `[文件] ${message.snippet}`;
         } else {
-            contentPreview.textContent = message.content;
+            contentPreview.innerHTML = message.snippet;
         }
         
         searchItem.appendChild(searchItemInfo);
         searchItem.appendChild(contentPreview);
         searchResults.appendChild(searchItem);
     });
+    
+    if (nextOffset) {
+        const more = document.createElement('button');
+        more.className = 'search-more';
+        more.textContent = '加载更多';
+        more.onclick = () => searchMessages(nextOffset);
+        searchResults.appendChild(more);
+    }
+}
+
+// 处理被@提到的通知：高亮当前显示的消息，不在该房间时提示
+function handleMention(message) {
+    const roomID = message.data ? message.data.room_id : 0;
+    const messageElement = messageMap.get(message.message_id);
+    if (messageElement) {
+        messageElement.classList.add('mentioned');
+    }
+    if (roomID !== currentRoomID || currentPeerID !== null) {
+        const roomName = message.data && message.data.room_name ? message.data.room_name : '其他房间';
+        renderSystemMessage({
+            content: `${message.username || '用户' + message.user_id} 在 ${roomName} 中提到了你：${message.content}`
+        });
+    }
+    
+    if (mentionsTabButton.classList.contains('active')) {
+        fetchMentions();
+        return;
+    }
+    newMentions++;
+    let badge = mentionsTabButton.querySelector('.unread-badge');
+    if (!badge) {
+        badge = document.createElement('span');
+        badge.className = 'unread-badge';
+        mentionsTabButton.appendChild(badge);
+    }
+    badge.textContent = newMentions > 99 ? '99+' : newMentions;
+}
+
+// 获取提到我的消息，before为空时获取最新的一页，最新的消息显示在最前面
+function fetchMentions(before) {
+    const params = new URLSearchParams({ limit: 20 });
+    if (before) params.set('before', before);
+    
+    fetch(`/api/mentions?${params}`)
+        .then(response => response.json())
+        .then(page => {
+            if (page.error) {
+                console.error('获取提到我的消息失败:', page.error);
+                return;
+            }
+            renderMentionList((page.messages || []).reverse(), page.next_cursor, Boolean(before));
+        })
+        .catch(error => console.error('获取提到我的消息失败:', error));
+}
+
+// 渲染提到我的消息，点击时切换到消息所在的房间
+function renderMentionList(messages, nextCursor, append) {
+    if (!append) {
+        mentionList.innerHTML = '';
+    }
+    const moreButton = mentionList.querySelector('.search-more');
+    if (moreButton) {
+        moreButton.remove();
+    }
+    
+    if (!append && messages.length === 0) {
+        const empty = document.createElement('div');
+        empty.textContent = '还没有人提到你';
+        mentionList.appendChild(empty);
+        return;
+    }
+    
+    messages.forEach(message => {
+        const item = document.createElement('div');
+        item.className = 'search-item';
+        
+        const info = document.createElement('div');
+        info.className = 'search-item-info';
+        
+        const userInfo = document.createElement('span');
+        userInfo.textContent = message.username || '用户' + message.user_id;
+        
+        const timeInfo = document.createElement('span');
+        timeInfo.textContent = formatDateTime(new Date(message.created_at));
+        
+        info.appendChild(userInfo);
+        info.appendChild(timeInfo);
+        
+        const content = document.createElement('div');
+        content.className = 'search-snippet';
+        content.textContent = message.status === 1 ? '此消息已被撤回' : message.content;
+        
+        item.appendChild(info);
+        item.appendChild(content);
+        item.addEventListener('click', () => {
+            if (currentPeerID !== null) closeConversation();
+            switchRoom(message.room_id);
+        });
+        mentionList.appendChild(item);
+    });
+    
+    if (nextCursor) {
+        const more = document.createElement('button');
+        more.className = 'search-more';
+        more.textContent = '加载更多';
+        more.onclick = () => fetchMentions(nextCursor);
+        mentionList.appendChild(more);
+    }
 }
 
 // 绑定事件
 function bindEvents() {
     // 发送消息
     sendButton.addEventListener('click', sendTextMessage);
+    messageInput.addEventListener('input', notifyTyping);
     messageInput.addEventListener('keydown', (e) => {
         if (e.key === 'Enter' && !e.shiftKey) {
             e.preventDefault();
@@ -603,6 +1851,32 @@ function bindEvents() {
     // 设置用户名
     setUsernameButton.addEventListener('click', setUsername);
     
+    // 设置在线状态，状态文字在回车或失去焦点时提交
+    presenceSelect.addEventListener('change', setPresence);
+    statusTextInput.addEventListener('change', setPresence);
+    
+    // 创建房间
+    createRoomButton.addEventListener('click', createRoom);
+    
+    // 关闭私信会话
+    closeConversationButton.addEventListener('click', closeConversation);
+    
+    // 回复和话题
+    cancelReplyButton.addEventListener('click', cancelReply);
+    closeThreadButton.addEventListener('click', closeThread);
+    
+    // 滚动到顶部时加载更早的消息
+    messagesContainer.addEventListener('scroll', () => {
+        if (messagesContainer.scrollTop === 0) {
+            fetchOlderMessages();
+        }
+    });
+    
+    // 登录、注册和退出
+    loginButton.addEventListener('click', () => submitAuth('/api/login'));
+    registerButton.addEventListener('click', () => submitAuth('/api/register'));
+    logoutButton.addEventListener('click', logout);
+    
     // 上传图片
     imageUpload.addEventListener('change', handleImageUpload);
     
@@ -618,7 +1892,7 @@ function bindEvents() {
     });
     
     // 搜索消息
-    searchButton.addEventListener('click', searchMessages);
+    searchButton.addEventListener('click', () => searchMessages());
     searchInput.addEventListener('keydown', (e) => {
         if (e.key === 'Enter') {
             searchMessages();
@@ -644,6 +1918,12 @@ function sendTextMessage() {
         content
     };
     
+    // 私信会话中发送私信
+    if (currentPeerID !== null) {
+        message.type = MESSAGE_TYPES.DIRECT;
+        message.target_id = currentPeerID;
+    }
+    
     sendMessage(message);
     messageInput.value = '';
 }
@@ -655,9 +1935,33 @@ function sendEmojiMessage(emoji) {
         content: emoji
     };
     
+    // 私信会话中以私信发送表情
+    if (currentPeerID !== null) {
+        message.type = MESSAGE_TYPES.DIRECT;
+        message.target_id = currentPeerID;
+    }
+    
     sendMessage(message);
 }
 
+// 上传文件到服务器，返回文件信息
+function uploadFile(file) {
+    const formData = new FormData();
+    formData.append('file', file);
+    
+    return fetch('/api/files', {
+        method: 'POST',
+        body: formData
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        return data;
+    });
+}
+
 // 处理图片上传
 function handleImageUpload(e) {
     const file = e.target.files[0];
@@ -668,17 +1972,25 @@ function handleImageUpload(e) {
         return;
     }
     
-    const reader = new FileReader();
-    reader.onload = function(e) {
-        const message = {
-            type: MESSAGE_TYPES.IMAGE,
-            content: e.target.result
-        };
-        
-        sendMessage(message);
-    };
+    if (currentPeerID !== null) {
+        alert('私信暂不支持发送图片');
+        imageUpload.value = '';
+        return;
+    }
+    
+    uploadFile(file)
+        .then(uploaded => {
+            // 服务端只把能正常解码的PNG、JPEG和GIF识别为图片
+            if (!uploaded.mime_type.startsWith('image/')) {
+                throw new Error('只能发送PNG、JPEG或GIF格式的图片');
+            }
+            sendMessage({
+                type: MESSAGE_TYPES.IMAGE,
+                file_id: uploaded.id
+            });
+        })
+        .catch(error => alert(error.message));
     
-    reader.readAsDataURL(file);
     // 清空文件选择器，使同一文件可以再次选择
     imageUpload.value = '';
 }
@@ -688,6 +2000,12 @@ function handleFileUpload(e) {
     const file = e.target.files[0];
     if (!file) return;
     
+    if (currentPeerID !== null) {
+        alert('私信暂不支持发送文件');
+        fileUpload.value = '';
+        return;
+    }
+    
     // 文件大小限制（10MB）
     const maxSize = 10 * 1024 * 1024;
     if (file.size > maxSize) {
@@ -695,19 +2013,15 @@ function handleFileUpload(e) {
         return;
     }
     
-    const reader = new FileReader();
-    reader.onload = function(e) {
-        const message = {
-            type: MESSAGE_TYPES.FILE,
-            content: e.target.result,
-            file_name: file.name,
-            file_size: file.size
-        };
-        
-        sendMessage(message);
-    };
+    uploadFile(file)
+        .then(uploaded => {
+            sendMessage({
+                type: MESSAGE_TYPES.FILE,
+                file_id: uploaded.id
+            });
+        })
+        .catch(error => alert(error.message));
     
-    reader.readAsDataURL(file);
     // 清空文件选择器，使同一文件可以再次选择
     fileUpload.value = '';
 }
@@ -728,8 +2042,76 @@ function setUsername() {
     updateDisplayedUsername(username);
 }
 
+// 设置在线状态和自定义状态文字
+function setPresence() {
+    sendMessage({
+        type: MESSAGE_TYPES.PRESENCE,
+        presence: presenceSelect.value,
+        content: statusTextInput.value.trim()
+    });
+}
+
+// 显示或更新用户列表项中的在线状态
+function renderPresence(userElement, presence, statusText) {
+    let presenceElement = userElement.querySelector('.user-item-presence');
+    if (!presenceElement) {
+        presenceElement = document.createElement('div');
+        userElement.querySelector('.user-item-name').after(presenceElement);
+    }
+    presence = PRESENCE_LABELS[presence] ? presence : 'online';
+    presenceElement.className = `user-item-presence presence-${presence}`;
+    presenceElement.textContent = PRESENCE_LABELS[presence] + (statusText ? ` · ${statusText}` : '');
+}
+
+// 用户上线或信息变化时添加或替换用户列表项
+function handlePresenceUpdate(user) {
+    const userElement = createUserItem(user);
+    const existing = userList.querySelector(`.user-item[data-user-id="${user.id}"]`);
+    if (existing) {
+        existing.replaceWith(userElement);
+    } else {
+        userList.appendChild(userElement);
+    }
+    syncOwnUser(user);
+    renderEmptyUserList();
+    renderUnreadBadges();
+    renderTypingIndicators();
+}
+
+// 用户的最后一个连接断开时从用户列表移除
+function handlePresenceLeave(userID) {
+    const existing = userList.querySelector(`.user-item[data-user-id="${userID}"]`);
+    if (existing) {
+        existing.remove();
+    }
+    renderEmptyUserList();
+}
+
 // 发送消息到服务器
 function sendMessage(message) {
+    // 聊天消息默认发送到当前房间
+    if (message.room_id === undefined) {
+        message.room_id = currentRoomID;
+    }
+    
+    // 正在回复时，下一条聊天消息作为回复发送
+    const isChatMessage = [MESSAGE_TYPES.TEXT, MESSAGE_TYPES.EMOJI, MESSAGE_TYPES.IMAGE, MESSAGE_TYPES.FILE, MESSAGE_TYPES.DIRECT].includes(message.type);
+    if (isChatMessage && replyToID !== null) {
+        message.reply_to = replyToID;
+        cancelReply();
+    }
+    
+    // 服务端收到聊天消息后自动结束输入状态
+    if (isChatMessage) {
+        typingTarget = null;
+    }
+    
+    // 需要确认的消息附带请求ID，服务端在ack或error中原样返回
+    if (isChatMessage || [MESSAGE_TYPES.EDIT, MESSAGE_TYPES.RECALL, MESSAGE_TYPES.USER].includes(message.type)) {
+        message.request_id = String(nextRequestID++);
+        pendingRequests.set(message.request_id, message);
+    }
+    
     if (socket && socket.readyState === WebSocket.OPEN) {
         socket.send(JSON.stringify(message));
     } else {
@@ -769,6 +2151,13 @@ function switchTab(tabName) {
         fetchStats();
     } else if (tabName === 'online-users') {
         fetchOnlineUsers();
+    } else if (tabName === 'rooms') {
+        fetchRooms();
+    } else if (tabName === 'mentions') {
+        newMentions = 0;
+        const badge = mentionsTabButton.querySelector('.unread-badge');
+        if (badge) badge.remove();
+        fetchMentions();
     }
 }
 
@@ -832,9 +2221,11 @@ function updateChatTitle(newTitle) {
     })
     .then(response => response.json())
     .then(data => {
-        if (!data.success) {
+        if (data.error) {
             // 如果更新失败，恢复原标题
-            document.getElementById('chat-title').textContent = data.title;
+            alert(data.error);
+            document.getElementById('chat-title').textContent = document.title;
+            return;
         }
         // 更新页面标题
         document.title = data.title;
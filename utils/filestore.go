@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidHash 表示文件哈希格式不正确
+var ErrInvalidHash = errors.New("文件哈希无效")
+
+// FileStore 是基于内容寻址的磁盘文件存储，相同内容只保存一份
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore 创建以dir为根目录的文件存储
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Save 保存文件内容，返回内容的SHA-256哈希和大小
+func (s *FileStore) Save(r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", 0, err
+	}
+
+	// 先写入临时文件，同时计算哈希
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	path, _ := s.Path(hash)
+
+	// 相同内容已存在时直接复用
+	if _, err := os.Stat(path); err == nil {
+		return hash, size, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+
+	return hash, size, nil
+}
+
+// Open 打开指定哈希对应的文件
+func (s *FileStore) Open(hash string) (*os.File, error) {
+	path, err := s.Path(hash)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Path 返回哈希对应的文件路径，按哈希前两位分目录存放
+func (s *FileStore) Path(hash string) (string, error) {
+	if len(hash) != sha256.Size*2 {
+		return "", ErrInvalidHash
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", ErrInvalidHash
+	}
+	return filepath.Join(s.dir, hash[:2], hash), nil
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket 是令牌桶限流器，每隔interval补充一个令牌，最多积累burst个
+type TokenBucket struct {
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	mutex    sync.Mutex
+}
+
+// NewTokenBucket 创建令牌桶，初始时令牌是满的
+func NewTokenBucket(interval time.Duration, burst int) *TokenBucket {
+	return &TokenBucket{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// 按经过的时间补充令牌，调用方需持有锁
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Allow 取出一个令牌，没有令牌时返回false和需要等待的时间
+func (b *TokenBucket) Allow() (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// Full 判断令牌是否已经补满，补满的桶与新建的桶没有区别，可以丢弃
+func (b *TokenBucket) Full() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(time.Now())
+	return b.tokens >= b.burst
+}
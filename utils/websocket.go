@@ -4,28 +4,56 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 	
 	"github.com/gorilla/websocket"
 )
 
-// 定义WebSocket连接升级器
+// 定义WebSocket连接升级器，默认只允许同源页面连接，可通过AllowOrigins放行其他来源
 var Upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	// 允许所有CORS请求
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:     SameOrigin(nil),
+}
+
+// SameOrigin 返回检查WebSocket请求来源的函数
+//
+// 身份来自会话Cookie，必须拒绝其他网站的页面以当前用户身份建立连接。
+// 允许没有Origin的非浏览器客户端、与请求Host相同的来源，以及allowed中列出的来源
+func SameOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, item := range allowed {
+			if strings.EqualFold(strings.TrimSuffix(item, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
 // Client 表示WebSocket客户端连接
 type Client struct {
-	ID     int64
-	IP     string
-	Conn   *websocket.Conn
-	Send   chan []byte
-	Hub    *Hub
+	ID          int64
+	IP          string
+	UserAgent   string    // 建立连接时的User-Agent，用于区分用户的多个设备
+	ConnectedAt time.Time // 建立连接的时间
+	Conn        *websocket.Conn
+	Send        chan []byte
+	Hub         *Hub
 }
 
 // 消息类型
@@ -35,10 +63,27 @@ const (
 	MessageTypeEmoji    = "emoji"    // 表情消息
 	MessageTypeSystem   = "system"   // 系统消息
 	MessageTypeUser     = "user"     // 用户信息更新
-	MessageTypeUsers    = "users"    // 在线用户列表
+	MessageTypeUsers    = "users"    // 在线用户列表，连接建立时发送一次，之后通过presence_*消息增量更新
 	MessageTypeStats    = "stats"    // 聊天室统计信息
 	MessageTypeFile     = "file"     // 文件消息
 	MessageTypeRecall   = "recall"   // 消息撤回
+	MessageTypeRoomJoin  = "room_join"  // 加入房间
+	MessageTypeRoomLeave = "room_leave" // 离开房间
+	MessageTypeRooms     = "rooms"      // 房间列表
+	MessageTypeDirect    = "direct"     // 一对一私信
+	MessageTypeReaction  = "reaction"   // 表情回应
+	MessageTypeEdit      = "edit"       // 消息编辑
+	MessageTypeRead      = "read"       // 已读回执
+	MessageTypeTyping    = "typing"     // 正在输入
+	MessageTypeMention   = "mention"    // 被@提到
+	MessageTypeWarning   = "warning"    // 发送过于频繁等警告，只发给相关客户端
+	MessageTypeError     = "error"      // 消息被拒绝的原因，只发给发送者
+	MessageTypeAck       = "ack"        // 确认消息已处理，只发给发送者
+	MessageTypeResync    = "resync"     // 错过的消息过多无法补发，客户端需要重新加载
+	MessageTypePresence  = "presence"   // 设置自己的在线状态
+	MessageTypePresenceJoin   = "presence_join"   // 用户上线，data为在线用户信息
+	MessageTypePresenceLeave  = "presence_leave"  // 用户的最后一个连接断开
+	MessageTypePresenceUpdate = "presence_update" // 在线用户的昵称、状态或设备变化，data为在线用户信息
 )
 
 // Message 代表从客户端发送或接收的消息
@@ -53,12 +98,44 @@ type Message struct {
 	FileName  string      `json:"file_name,omitempty"`  // 文件名
 	FileSize  int64       `json:"file_size,omitempty"`  // 文件大小
 	Status    int         `json:"status,omitempty"`     // 消息状态
+	RoomID    int64       `json:"room_id,omitempty"`    // 房间ID，为0时发送给所有客户端
+	TargetID  int64       `json:"target_id,omitempty"`  // 私信接收者的用户ID
+	FileID    int64       `json:"file_id,omitempty"`    // 图片和文件消息引用的文件ID
+	Emoji     string      `json:"emoji,omitempty"`      // 表情回应内容
+	ReplyTo   int64       `json:"reply_to,omitempty"`   // 被回复消息的ID
+	RequestID string      `json:"request_id,omitempty"` // 客户端提供的请求ID，在ack和error中原样返回
+	Presence  string      `json:"presence,omitempty"`   // 在线状态：online、away、busy或dnd
+}
+
+// envelope 是待投递的消息及其投递范围
+type envelope struct {
+	roomID int64   // 非0时只投递给该房间成员
+	client  *Client // 非空时只投递给该客户端
+	userIDs []int64 // 非空时只投递给这些用户的所有连接
+	exclude *Client // 非空时不投递给该客户端
+	data    []byte
+}
+
+// roomRequest 表示客户端加入或离开房间的请求
+type roomRequest struct {
+	client *Client
+	roomID int64
+}
+
+// disconnectRequest 表示断开满足条件的客户端的请求
+type disconnectRequest struct {
+	match func(client *Client) bool
+	data  []byte // 非空时在断开前发送给这些客户端
 }
 
 // Hub 管理所有活动的客户端连接
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	rooms      map[int64]map[*Client]bool
+	broadcast  chan envelope
+	join       chan roomRequest
+	leave      chan roomRequest
+	disconnect chan disconnectRequest
 	Register   chan *Client
 	Unregister chan *Client
 	mutex      sync.Mutex
@@ -67,10 +144,14 @@ type Hub struct {
 // NewHub 创建新的Hub实例
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan envelope),
+		join:       make(chan roomRequest),
+		leave:      make(chan roomRequest),
+		disconnect: make(chan disconnectRequest),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
+		rooms:      make(map[int64]map[*Client]bool),
 	}
 }
 
@@ -84,19 +165,53 @@ func (h *Hub) Run() {
 			h.mutex.Unlock()
 		case client := <-h.Unregister:
 			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
+			h.removeClient(client)
+			h.mutex.Unlock()
+		case req := <-h.join:
+			h.mutex.Lock()
+			if _, ok := h.clients[req.client]; ok {
+				members, exists := h.rooms[req.roomID]
+				if !exists {
+					members = make(map[*Client]bool)
+					h.rooms[req.roomID] = members
+				}
+				members[req.client] = true
 			}
 			h.mutex.Unlock()
-		case message := <-h.broadcast:
+		case req := <-h.leave:
+			h.mutex.Lock()
+			if members, exists := h.rooms[req.roomID]; exists {
+				delete(members, req.client)
+				if len(members) == 0 {
+					delete(h.rooms, req.roomID)
+				}
+			}
+			h.mutex.Unlock()
+		case req := <-h.disconnect:
 			h.mutex.Lock()
 			for client := range h.clients {
+				if !req.match(client) {
+					continue
+				}
+				if req.data != nil {
+					select {
+					case client.Send <- req.data:
+					default:
+					}
+				}
+				h.removeClient(client)
+			}
+			h.mutex.Unlock()
+		case env := <-h.broadcast:
+			h.mutex.Lock()
+			for _, client := range h.targets(env) {
+				if client == env.exclude {
+					continue
+				}
 				select {
-				case client.Send <- message:
+				case client.Send <- env.data:
 				default:
-					close(client.Send)
-					delete(h.clients, client)
+					h.removeClient(client)
 				}
 			}
 			h.mutex.Unlock()
@@ -104,7 +219,115 @@ func (h *Hub) Run() {
 	}
 }
 
-// BroadcastMessage 向所有客户端广播消息
+// targets 返回消息的投递对象，调用方需持有锁
+func (h *Hub) targets(env envelope) []*Client {
+	targets := make([]*Client, 0)
+	switch {
+	case env.client != nil:
+		if _, ok := h.clients[env.client]; ok {
+			targets = append(targets, env.client)
+		}
+	case len(env.userIDs) > 0:
+		for client := range h.clients {
+			for _, userID := range env.userIDs {
+				if client.ID == userID {
+					targets = append(targets, client)
+					break
+				}
+			}
+		}
+	case env.roomID != 0:
+		for client := range h.rooms[env.roomID] {
+			targets = append(targets, client)
+		}
+	default:
+		for client := range h.clients {
+			targets = append(targets, client)
+		}
+	}
+	return targets
+}
+
+// removeClient 移除客户端及其所有房间成员关系，调用方需持有锁
+func (h *Hub) removeClient(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+	close(client.Send)
+	
+	for roomID, members := range h.rooms {
+		delete(members, client)
+		if len(members) == 0 {
+			delete(h.rooms, roomID)
+		}
+	}
+}
+
+// JoinRoom 将客户端加入房间
+func (h *Hub) JoinRoom(client *Client, roomID int64) {
+	h.join <- roomRequest{client: client, roomID: roomID}
+}
+
+// LeaveRoom 将客户端移出房间
+func (h *Hub) LeaveRoom(client *Client, roomID int64) {
+	h.leave <- roomRequest{client: client, roomID: roomID}
+}
+
+// Disconnect 断开所有满足条件的客户端，notice不为空时作为最后一条消息发送给这些客户端
+//
+// 之前发送给这些客户端的消息仍会先写出，然后连接被关闭
+func (h *Hub) Disconnect(match func(client *Client) bool, notice *Message) {
+	req := disconnectRequest{match: match}
+	if notice != nil {
+		data, err := json.Marshal(notice)
+		if err != nil {
+			log.Printf("错误: 消息序列化失败: %v", err)
+		} else {
+			req.data = data
+		}
+	}
+	h.disconnect <- req
+}
+
+// IsMember 检查客户端是否已加入房间
+func (h *Hub) IsMember(client *Client, roomID int64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.rooms[roomID][client]
+}
+
+// ClientRooms 返回客户端已加入的房间ID列表
+func (h *Hub) ClientRooms(client *Client) []int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	
+	roomIDs := make([]int64, 0)
+	for roomID, members := range h.rooms {
+		if members[client] {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs
+}
+
+// RoomMemberIDs 返回房间内在线用户的ID列表（去重）
+func (h *Hub) RoomMemberIDs(roomID int64) []int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	
+	seen := make(map[int64]bool)
+	userIDs := make([]int64, 0)
+	for client := range h.rooms[roomID] {
+		if !seen[client.ID] {
+			seen[client.ID] = true
+			userIDs = append(userIDs, client.ID)
+		}
+	}
+	return userIDs
+}
+
+// BroadcastMessage 广播消息，设置了RoomID时只发送给该房间成员
 func (h *Hub) BroadcastMessage(msg *Message) {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -112,5 +335,38 @@ func (h *Hub) BroadcastMessage(msg *Message) {
 		return
 	}
 	
-	h.broadcast <- data
+	h.broadcast <- envelope{roomID: msg.RoomID, data: data}
+}
+
+// BroadcastExcept 与BroadcastMessage相同，但不发送给指定的客户端
+func (h *Hub) BroadcastExcept(msg *Message, except *Client) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("错误: 消息序列化失败: %v", err)
+		return
+	}
+	
+	h.broadcast <- envelope{roomID: msg.RoomID, exclude: except, data: data}
+}
+
+// SendToClient 只向指定客户端发送消息
+func (h *Hub) SendToClient(client *Client, msg *Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("错误: 消息序列化失败: %v", err)
+		return
+	}
+	
+	h.broadcast <- envelope{client: client, data: data}
+}
+
+// SendToUsers 向指定用户的所有连接发送消息
+func (h *Hub) SendToUsers(userIDs []int64, msg *Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("错误: 消息序列化失败: %v", err)
+		return
+	}
+	
+	h.broadcast <- envelope{userIDs: userIDs, data: data}
 } 
\ No newline at end of file